@@ -0,0 +1,359 @@
+package binproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// ArrayKind is the element type of an Array unit.
+type ArrayKind byte
+
+// Possible ArrayKind values
+const (
+	ArrayInt64 ArrayKind = iota
+	ArrayInt32
+	ArrayUint8
+	ArrayFloat64
+)
+
+// size returns the size of an element in bytes, 0 for unknown kinds.
+func (k ArrayKind) size() int {
+	switch k {
+	case ArrayInt64, ArrayFloat64:
+		return 8
+	case ArrayInt32:
+		return 4
+	case ArrayUint8:
+		return 1
+	}
+	return 0
+}
+
+func (k ArrayKind) String() string {
+	switch k {
+	case ArrayInt64:
+		return "int64"
+	case ArrayInt32:
+		return "int32"
+	case ArrayUint8:
+		return "uint8"
+	case ArrayFloat64:
+		return "float64"
+	}
+	return "unknown"
+}
+
+// Errors of Arrays.
+var (
+	UnknownArrayKind   = errors.New("Unknown array kind")
+	WrongArrayKind     = errors.New("Array has a different kind")
+	ArrayTooLarge      = errors.New("Array has too many elements")
+	ArrayCountMismatch = errors.New("Number of written array elements does not match the announced count")
+	InvalidArrayTarget = errors.New("Array elements can not be stored in the given variable")
+)
+
+// ArrayReader reads the elements of an Array unit. The elements are read lazily, so large arrays can be processed in parts.
+// The Read* methods only work, if the array has the corresponding kind, otherwise WrongArrayKind is returned.
+type ArrayReader struct {
+	lazyPayload
+	r    io.Reader
+	kind ArrayKind
+	n    int
+	left int
+	err  error
+}
+
+func newArrayReader(r io.Reader) (*ArrayReader, error) {
+	var hdr struct {
+		Kind  ArrayKind
+		Count uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Kind.size() == 0 {
+		return nil, UnknownArrayKind // We don't know the size of the data, so we can not continue reading.
+	}
+
+	ar := &ArrayReader{lazyPayload: newLazyPayload(), r: r, kind: hdr.Kind, n: int(hdr.Count), left: int(hdr.Count)}
+	if ar.left == 0 {
+		ar.finish()
+	}
+	return ar, nil
+}
+
+func (ar *ArrayReader) finish() {
+	ar.err = io.EOF
+	close(ar.done)
+}
+
+func (ar *ArrayReader) fail(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	ar.err = err
+	ar.broken = err
+	close(ar.done)
+	return err
+}
+
+// Kind returns the kind of the elements.
+func (ar *ArrayReader) Kind() ArrayKind { return ar.kind }
+
+// Len returns the number of elements of the array.
+func (ar *ArrayReader) Len() int { return ar.n }
+
+// Remaining returns the number of elements that were not read yet.
+func (ar *ArrayReader) Remaining() int { return ar.left }
+
+// read reads up to n elements of the given kind and calls decode for each of them.
+// At the end of the array, io.EOF is returned.
+func (ar *ArrayReader) read(kind ArrayKind, n int, decode func(b []byte, i int)) (int, error) {
+	switch {
+	case ar.err != nil:
+		return 0, ar.err
+	case kind != ar.kind:
+		return 0, WrongArrayKind
+	}
+
+	size := kind.size()
+	if n > ar.left {
+		n = ar.left
+	}
+	if n > copyBufSize/size {
+		n = copyBufSize / size
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	buf := make([]byte, n*size)
+	if _, err := io.ReadFull(ar.r, buf); err != nil {
+		return 0, ar.fail(err)
+	}
+	for i := 0; i < n; i++ {
+		decode(buf[i*size:], i)
+	}
+
+	ar.left -= n
+	if ar.left == 0 {
+		ar.finish()
+	}
+	return n, nil
+}
+
+// ReadInt64s reads up to len(p) elements of an int64 array into p.
+func (ar *ArrayReader) ReadInt64s(p []int64) (int, error) {
+	return ar.read(ArrayInt64, len(p), func(b []byte, i int) { p[i] = int64(binary.LittleEndian.Uint64(b)) })
+}
+
+// ReadInt32s reads up to len(p) elements of an int32 array into p.
+func (ar *ArrayReader) ReadInt32s(p []int32) (int, error) {
+	return ar.read(ArrayInt32, len(p), func(b []byte, i int) { p[i] = int32(binary.LittleEndian.Uint32(b)) })
+}
+
+// ReadUint8s reads up to len(p) elements of a uint8 array into p.
+func (ar *ArrayReader) ReadUint8s(p []uint8) (int, error) {
+	return ar.read(ArrayUint8, len(p), func(b []byte, i int) { p[i] = b[0] })
+}
+
+// ReadFloat64s reads up to len(p) elements of a float64 array into p.
+func (ar *ArrayReader) ReadFloat64s(p []float64) (int, error) {
+	return ar.read(ArrayFloat64, len(p), func(b []byte, i int) { p[i] = math.Float64frombits(binary.LittleEndian.Uint64(b)) })
+}
+
+// ReadAll reads all remaining elements. Depending on the kind, a []int64, []int32, []uint8 or []float64 is returned.
+func (ar *ArrayReader) ReadAll() (interface{}, error) {
+	var out interface{}
+	var err error
+
+	// Don't trust the count, the slices grow while the data arrives.
+	capHint := ar.left
+	if capHint > copyBufSize {
+		capHint = copyBufSize
+	}
+
+	switch ar.kind {
+	case ArrayInt64:
+		s := make([]int64, 0, capHint)
+		err = ar.readAll(func(b []byte, i int) { s = append(s, int64(binary.LittleEndian.Uint64(b))) })
+		out = s
+	case ArrayInt32:
+		s := make([]int32, 0, capHint)
+		err = ar.readAll(func(b []byte, i int) { s = append(s, int32(binary.LittleEndian.Uint32(b))) })
+		out = s
+	case ArrayUint8:
+		s := make([]uint8, 0, capHint)
+		err = ar.readAll(func(b []byte, i int) { s = append(s, b[0]) })
+		out = s
+	case ArrayFloat64:
+		s := make([]float64, 0, capHint)
+		err = ar.readAll(func(b []byte, i int) { s = append(s, math.Float64frombits(binary.LittleEndian.Uint64(b))) })
+		out = s
+	}
+
+	return out, err
+}
+
+func (ar *ArrayReader) readAll(decode func(b []byte, i int)) error {
+	for {
+		_, err := ar.read(ar.kind, ar.left, decode)
+		switch err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// Close implements io.Closer. It skips the remaining elements, so the parent SimpleUnitReader can be used again.
+func (ar *ArrayReader) Close() error {
+	switch ar.err {
+	case nil:
+	case io.EOF:
+		return nil
+	default:
+		return ar.err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, ar.r, int64(ar.left)*int64(ar.kind.size())); err != nil {
+		return ar.fail(err)
+	}
+	ar.left = 0
+	ar.finish()
+	return nil
+}
+
+// ArrayWriter writes the elements of an Array unit. See InitArray.
+type ArrayWriter struct {
+	w    io.Writer
+	kind ArrayKind
+	left int
+	err  error
+}
+
+// InitArray starts an Array with count elements of the given kind.
+// Exactly count elements must be written with the Write* method of the kind, then the ArrayWriter must be closed.
+func InitArray(w io.Writer, kind ArrayKind, count int) (*ArrayWriter, error) {
+	switch {
+	case kind.size() == 0:
+		return nil, UnknownArrayKind
+	case count < 0 || uint64(count) > math.MaxUint32:
+		return nil, ArrayTooLarge
+	}
+
+	hdr := []byte{UTArray, byte(kind), 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(hdr[2:], uint32(count))
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &ArrayWriter{w: w, kind: kind, left: count}, nil
+}
+
+// write encodes n elements of the given kind with encode and sends them.
+func (aw *ArrayWriter) write(kind ArrayKind, n int, encode func(b []byte, i int)) error {
+	switch {
+	case aw.err != nil:
+		return aw.err
+	case kind != aw.kind:
+		return WrongArrayKind
+	case n > aw.left:
+		return ArrayCountMismatch
+	}
+
+	size := kind.size()
+	perBuf := copyBufSize / size
+	buf := make([]byte, 0, perBuf*size)
+	for off := 0; off < n; off += perBuf {
+		m := n - off
+		if m > perBuf {
+			m = perBuf
+		}
+
+		buf = buf[:m*size]
+		for i := 0; i < m; i++ {
+			encode(buf[i*size:], off+i)
+		}
+		if _, err := aw.w.Write(buf); err != nil {
+			aw.err = err
+			return err
+		}
+		aw.left -= m
+	}
+	return nil
+}
+
+// WriteInt64s writes elements of an int64 array.
+func (aw *ArrayWriter) WriteInt64s(p []int64) error {
+	return aw.write(ArrayInt64, len(p), func(b []byte, i int) { binary.LittleEndian.PutUint64(b, uint64(p[i])) })
+}
+
+// WriteInt32s writes elements of an int32 array.
+func (aw *ArrayWriter) WriteInt32s(p []int32) error {
+	return aw.write(ArrayInt32, len(p), func(b []byte, i int) { binary.LittleEndian.PutUint32(b, uint32(p[i])) })
+}
+
+// WriteUint8s writes elements of a uint8 array.
+func (aw *ArrayWriter) WriteUint8s(p []uint8) error {
+	return aw.write(ArrayUint8, len(p), func(b []byte, i int) { b[0] = p[i] })
+}
+
+// WriteFloat64s writes elements of a float64 array.
+func (aw *ArrayWriter) WriteFloat64s(p []float64) error {
+	return aw.write(ArrayFloat64, len(p), func(b []byte, i int) { binary.LittleEndian.PutUint64(b, math.Float64bits(p[i])) })
+}
+
+// Close checks, if all announced elements were written. If not, ArrayCountMismatch is returned.
+// The Array is incomplete in that case, the connection should not be used any more.
+func (aw *ArrayWriter) Close() error {
+	if aw.err != nil {
+		return aw.err
+	}
+	if aw.left != 0 {
+		aw.err = ArrayCountMismatch
+	}
+	return aw.err
+}
+
+// SendArray sends an Array unit. data must be a []int64, []int32, []uint8 or []float64.
+func SendArray(w io.Writer, data interface{}) error {
+	var kind ArrayKind
+	var n int
+	switch s := data.(type) {
+	case []int64:
+		kind, n = ArrayInt64, len(s)
+	case []int32:
+		kind, n = ArrayInt32, len(s)
+	case []uint8:
+		kind, n = ArrayUint8, len(s)
+	case []float64:
+		kind, n = ArrayFloat64, len(s)
+	default:
+		return UnknownArrayKind
+	}
+
+	aw, err := InitArray(w, kind, n)
+	if err != nil {
+		return err
+	}
+
+	switch s := data.(type) {
+	case []int64:
+		err = aw.WriteInt64s(s)
+	case []int32:
+		err = aw.WriteInt32s(s)
+	case []uint8:
+		err = aw.WriteUint8s(s)
+	case []float64:
+		err = aw.WriteFloat64s(s)
+	}
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
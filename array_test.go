@@ -0,0 +1,189 @@
+package binproto
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestArray(t *testing.T) {
+	arrays := []interface{}{
+		[]int64{1, -2, 1 << 40},
+		[]int32{-1, 2, 1 << 30},
+		[]uint8{0, 1, 255},
+		[]float64{0.5, -1e100},
+		[]int64{},
+	}
+
+	w := new(bytes.Buffer)
+	vw := NewValidatingWriter(w)
+	for _, arr := range arrays {
+		chkerr(t, SendArray(vw, arr), "SendArray")
+	}
+	chkerr(t, vw.CheckComplete(), "CheckComplete")
+
+	if err := SendArray(w, []string{"foo"}); err != UnknownArrayKind {
+		t.Errorf("Expected UnknownArrayKind, got: %v", err)
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	for _, want := range arrays {
+		ar := readExpect2(t, ur, UTArray).(*ArrayReader)
+		got, err := ar.ReadAll()
+		if err != nil {
+			t.Fatalf("Could not read array: %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Wrong array: got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestArrayStreaming(t *testing.T) {
+	const n = 100000
+
+	w := new(bytes.Buffer)
+	aw, err := InitArray(w, ArrayInt64, n)
+	chkerr(t, err, "InitArray")
+	for i := 0; i < n; i += 1000 {
+		part := make([]int64, 1000)
+		for j := range part {
+			part[j] = int64(i + j)
+		}
+		chkerr(t, aw.WriteInt64s(part), "WriteInt64s")
+	}
+	if err := aw.WriteInt64s([]int64{0}); err != ArrayCountMismatch {
+		t.Errorf("Expected ArrayCountMismatch, got: %v", err)
+	}
+	chkerr(t, aw.Close(), "Close")
+	chkerr(t, SendNumber(w, 42), "SendNumber")
+
+	if w.Len() != 1+5+8*n+9 {
+		t.Errorf("Wrong size: %d", w.Len())
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	ar := readExpect2(t, ur, UTArray).(*ArrayReader)
+	if ar.Kind() != ArrayInt64 || ar.Len() != n {
+		t.Fatalf("Wrong kind or length: %s, %d", ar.Kind(), ar.Len())
+	}
+	if _, err := ar.ReadFloat64s(make([]float64, 1)); err != WrongArrayKind {
+		t.Errorf("Expected WrongArrayKind, got: %v", err)
+	}
+
+	buf := make([]int64, 777)
+	next := int64(0)
+	for {
+		m, err := ar.ReadInt64s(buf)
+		for _, x := range buf[:m] {
+			if x != next {
+				t.Fatalf("Wrong element: got %d, want %d", x, next)
+			}
+			next++
+		}
+		if err == io.EOF {
+			break
+		}
+		chkerr(t, err, "ReadInt64s")
+	}
+	if next != n {
+		t.Errorf("Read %d elements, expected %d", next, n)
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 42 {
+		t.Errorf("Wrong number after array: %d", n)
+	}
+
+	// Partially read, then closed
+	ur = NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	ar = readExpect2(t, ur, UTArray).(*ArrayReader)
+	if _, err := ar.ReadInt64s(buf); err != nil {
+		t.Fatalf("Could not read array: %s", err)
+	}
+	if _, _, err := ur.ReadUnit(); err != ErrStreamOpen {
+		t.Errorf("Expected ErrStreamOpen, got: %v", err)
+	}
+	chkerr(t, ar.Close(), "Close")
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 42 {
+		t.Errorf("Wrong number after array: %d", n)
+	}
+}
+
+func TestArrayInIdKVMap(t *testing.T) {
+	w := new(bytes.Buffer)
+	chkerr(t, NewBuilder(w).IdKVMap(func(m *IdKVMapBuilder) {
+		m.Array(1, []float64{1, 2}).Array(2, []uint8{3}).Number(3, 4)
+	}).Err(), "Builder")
+
+	var fs []float64
+	var i32s []int32
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	readExpect2(t, ur, UTIdKVMap)
+	err := ScanIdKVMap(ur, map[byte]UKeyGetter{
+		1: {Type: UTArray, Action: ActionStoreArray(&fs)},
+		2: {Type: UTArray, Action: ActionStoreArray(&i32s)},
+		3: {Type: UTNumber, Action: ActionSkip(UTNumber)},
+	}, true)
+	if err != WrongArrayKind {
+		t.Errorf("Expected WrongArrayKind, got: %v", err)
+	}
+	if !reflect.DeepEqual(fs, []float64{1, 2}) {
+		t.Errorf("Wrong array stored: %v", fs)
+	}
+
+	ur = NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if err := SkipNext(ur); err != nil {
+		t.Fatalf("Skipping failed: %s", err)
+	}
+	if _, _, err := ur.ReadUnit(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got: %v", err)
+	}
+}
+
+func TestUnknownArrayKind(t *testing.T) {
+	raw := []byte{
+		0x00,                               // Nil
+		0x17, 0x09, 0x01, 0x00, 0x00, 0x00, // Array of unknown kind with one element
+		0x00, // Element data
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(raw))
+	readExpect2(t, ur, UTNil)
+	for i := 0; i < 2; i++ {
+		if _, _, err := ur.ReadUnit(); err != UnknownArrayKind {
+			t.Errorf("Read %d: expected UnknownArrayKind, got: %v", i, err)
+		}
+	}
+
+	w := new(bytes.Buffer)
+	vw := NewValidatingWriter(w)
+	if _, err := vw.Write(raw); err == nil {
+		t.Error("Unknown array kind accepted")
+	}
+	if !bytes.Equal(w.Bytes(), raw[:1]) {
+		t.Errorf("Wrong data passed through: %v", w.Bytes())
+	}
+}
+
+type myInt int64
+
+func TestActionStoreArrayInvalidTarget(t *testing.T) {
+	w := new(bytes.Buffer)
+	for i := 0; i < 3; i++ {
+		chkerr(t, SendArray(w, []int64{1, 2}), "SendArray")
+	}
+	chkerr(t, SendNumber(w, 5), "SendNumber")
+
+	var named []myInt
+	var nilPtr *[]int64
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	for _, dst := range []interface{}{&named, named, nilPtr} {
+		err, fatal := ActionStoreArray(dst)(readExpect2(t, ur, UTArray), ur)
+		if err != InvalidArrayTarget || fatal {
+			t.Errorf("%T: expected non-fatal InvalidArrayTarget, got: %v, %v", dst, err, fatal)
+		}
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 5 {
+		t.Errorf("Wrong number after arrays: %d", n)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"testing"
+	"time"
 )
 
 var data = []byte{
@@ -260,3 +261,122 @@ func TestSkipping(t *testing.T) {
 		t.Errorf("ReadUnit returned with unexpected data: %s, %v, %s", ut, data, err)
 	}
 }
+
+func TestFloat(t *testing.T) {
+	w := new(bytes.Buffer)
+	chkerr(t, InitIdKVMap(w), "InitIdKVMap")
+	chkerr(t, SendUKey(w, 1), "SendUKey")
+	chkerr(t, SendFloat(w, 1.5), "SendFloat")
+	chkerr(t, SendTerm(w), "SendTerm")
+	chkerr(t, SendFloat(w, -0.25), "SendFloat")
+
+	want := []byte{
+		0x08,       // IdKVMap
+		0x09, 0x01, // UKey(1)
+		0x0e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f, // Float(1.5)
+		0x0b,                                                 // Term
+		0x0e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xd0, 0xbf} // Float(-0.25)
+	if !bytes.Equal(w.Bytes(), want) {
+		t.Fatalf("Wrong data constructed, got: %v", w.Bytes())
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(want))
+	readExpect2(t, ur, UTIdKVMap)
+	var f float64
+	err := ScanIdKVMap(ur, map[byte]UKeyGetter{
+		1: {Type: UTFloat, Action: ActionStoreFloat(&f)},
+	}, true)
+	if err != nil {
+		t.Fatalf("ScanIdKVMap failed: %s", err)
+	}
+	if f != 1.5 {
+		t.Errorf("Wrong float stored: %g", f)
+	}
+	if f := readExpect2(t, ur, UTFloat).(float64); f != -0.25 {
+		t.Errorf("Wrong float read: %g", f)
+	}
+
+	ur = NewSimpleUnitReader(bytes.NewReader(want))
+	if err := SkipNext(ur); err != nil {
+		t.Fatalf("Skipping failed: %s", err)
+	}
+	if f := readExpect2(t, ur, UTFloat).(float64); f != -0.25 {
+		t.Errorf("Wrong float after skipping: %g", f)
+	}
+}
+
+func TestString(t *testing.T) {
+	w := new(bytes.Buffer)
+	chkerr(t, InitTextKVMap(w), "InitTextKVMap")
+	chkerr(t, SendString(w, "größe"), "SendString")
+	chkerr(t, SendString(w, "äöü"), "SendString")
+	chkerr(t, SendTextKey(w, "foo"), "SendTextKey")
+	chkerr(t, SendNumber(w, 1), "SendNumber")
+	chkerr(t, SendTerm(w), "SendTerm")
+
+	if err := SendString(w, "\xff"); err != InvalidUTF8 {
+		t.Errorf("Expected InvalidUTF8, got: %v", err)
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	readExpect2(t, ur, UTTextKVMap)
+	kvp, err := ReadTextKVPair(ur)
+	if err != nil {
+		t.Fatalf("Could not read pair: %s", err)
+	}
+	if kvp.Key != "größe" || kvp.ValueType != UTString || kvp.ValuePayload.(string) != "äöü" {
+		t.Errorf("Wrong pair: %v", kvp)
+	}
+	if kvp, err := ReadTextKVPair(ur); err != nil || kvp.Key != "foo" {
+		t.Errorf("Wrong pair: %v, %v", kvp, err)
+	}
+	if _, err := ReadTextKVPair(ur); err != Terminated {
+		t.Errorf("Expected Terminated, got: %v", err)
+	}
+
+	ur = NewSimpleUnitReader(bytes.NewReader([]byte{
+		0x14, 0x01, 0x00, 0x00, 0x00, 0xff, // String(invalid)
+		0x00})) // Nil
+	if _, _, err := ur.ReadUnit(); err != InvalidUTF8 {
+		t.Errorf("Expected InvalidUTF8, got: %v", err)
+	}
+	readExpect2(t, ur, UTNil)
+}
+
+func TestTime(t *testing.T) {
+	utc := time.Date(2016, 8, 16, 12, 0, 0, 123456789, time.UTC)
+	zoned := time.Date(1900, 1, 1, 0, 0, 0, 1, time.FixedZone("", -(3*3600+30*60)))
+
+	w := new(bytes.Buffer)
+	chkerr(t, SendTime(w, utc), "SendTime")
+	chkerr(t, SendTime(w, zoned), "SendTime")
+	chkerr(t, SendDuration(w, -90*time.Second), "SendDuration")
+	if w.Len() != 14+18+9 {
+		t.Errorf("Wrong length: %d", w.Len())
+	}
+
+	vw := NewValidatingWriter(ioutil.Discard)
+	if _, err := vw.Write(w.Bytes()); err != nil {
+		t.Errorf("Validation failed: %s", err)
+	}
+	chkerr(t, vw.CheckComplete(), "CheckComplete")
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if got := readExpect2(t, ur, UTTime).(time.Time); !got.Equal(utc) || got.Location() != time.UTC {
+		t.Errorf("Wrong time: %s", got)
+	}
+	got := readExpect2(t, ur, UTTime).(time.Time)
+	if _, offset := got.Zone(); !got.Equal(zoned) || offset != -(3*3600+30*60) {
+		t.Errorf("Wrong time: %s", got)
+	}
+	if d := readExpect2(t, ur, UTDuration).(time.Duration); d != -90*time.Second {
+		t.Errorf("Wrong duration: %s", d)
+	}
+
+	ur = NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	for i := 0; i < 3; i++ {
+		if err := SkipNext(ur); err != nil {
+			t.Fatalf("Skipping failed: %s", err)
+		}
+	}
+}
@@ -3,14 +3,17 @@ package main
 import (
 	"bufio"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/silvasur/binproto"
 	"io"
+	"math/big"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -37,9 +40,11 @@ func displayIncoming(r io.Reader, prefix string) {
 
 	for {
 		ut, data, err := ur.ReadUnit()
-		switch err {
-		case nil:
-		case io.EOF:
+		switch {
+		case err == nil:
+		case err == binproto.UnknownUnit && ut >= binproto.UTExtFirst:
+			// Unregistered extension unit, data is the raw payload.
+		case err == io.EOF:
 			return
 		default:
 			fmt.Fprintf(os.Stderr, "could not read next unit: %s\n", err)
@@ -57,8 +62,20 @@ func displayIncoming(r io.Reader, prefix string) {
 			out("Event %d", data.(uint16))
 		case binproto.UTBin:
 			out("Bin %s", strconv.Quote(string(data.([]byte))))
+		case binproto.UTString:
+			out("String %s", strconv.Quote(data.(string)))
 		case binproto.UTNumber:
 			out("Num %d", data.(int64))
+		case binproto.UTUint64:
+			out("Uint64 %d", data.(uint64))
+		case binproto.UTBigInt:
+			out("BigInt %s", data.(*big.Int))
+		case binproto.UTFloat:
+			out("Float %g", data.(float64))
+		case binproto.UTTime:
+			out("Time %s", data.(time.Time).Format(time.RFC3339Nano))
+		case binproto.UTDuration:
+			out("Duration %s", data.(time.Duration))
 		case binproto.UTList:
 			out("List")
 			indent += " "
@@ -82,9 +99,21 @@ func displayIncoming(r io.Reader, prefix string) {
 				fmt.Fprintf(os.Stderr, "error while dumping binstream: %s\n", err)
 				os.Exit(1)
 			}
+		case binproto.UTArray:
+			ar := data.(*binproto.ArrayReader)
+			s, err := ar.ReadAll()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error while reading array: %s\n", err)
+				os.Exit(1)
+			}
+			out("Array %s %v", ar.Kind(), s)
 		case binproto.UTTerm:
 			out("Term")
 			indent = dedent(indent)
+		default:
+			if ut >= binproto.UTExtFirst {
+				out("%s %s", ut, binproto.FormatExt(ut, data))
+			}
 		}
 	}
 }
@@ -96,12 +125,19 @@ Request <num>
 Answer <num>
 Event <num>
 Bin <go string>
+String <go string>
 Number <num>
+Uint64 <num>
+BigInt <num>
+Float <num>
+Time <RFC 3339 time>
+Duration <duration, e.g. 1h2m3.5s>
 List
 TextKVMap
 IdKVMap
 UKey <num>
 BinStream <file>
+Array <int64|int32|uint8|float64> <num> ...
 Term
 `)
 }
@@ -120,6 +156,63 @@ func getuint(parts []string, bits int) (uint64, bool) {
 	return n, true
 }
 
+func parseArray(fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("kind missing")
+	}
+
+	kind, nums := fields[0], fields[1:]
+	switch kind {
+	case "int64", "int32", "uint8":
+		ns := make([]int64, len(nums))
+		for i, s := range nums {
+			var n int64
+			var err error
+			switch kind {
+			case "int64":
+				n, err = strconv.ParseInt(s, 0, 64)
+			case "int32":
+				n, err = strconv.ParseInt(s, 0, 32)
+			case "uint8":
+				var u uint64
+				u, err = strconv.ParseUint(s, 0, 8)
+				n = int64(u)
+			}
+			if err != nil {
+				return nil, err
+			}
+			ns[i] = n
+		}
+
+		switch kind {
+		case "int32":
+			arr := make([]int32, len(ns))
+			for i, n := range ns {
+				arr[i] = int32(n)
+			}
+			return arr, nil
+		case "uint8":
+			arr := make([]uint8, len(ns))
+			for i, n := range ns {
+				arr[i] = uint8(n)
+			}
+			return arr, nil
+		}
+		return ns, nil
+	case "float64":
+		arr := make([]float64, len(nums))
+		for i, s := range nums {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = f
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("unknown kind '%s'", kind)
+}
+
 func client() int {
 	conn, err := net.Dial("tcp", *raddr)
 	if err != nil {
@@ -193,6 +286,26 @@ readloop:
 				fmt.Fprintln(os.Stderr, err)
 				return 1
 			}
+		case "string":
+			if len(parts) != 2 {
+				clientUsage()
+				continue readloop
+			}
+			s, err := strconv.Unquote(strings.TrimSpace(parts[1]))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not interpret string: %s\n", err)
+				clientUsage()
+				continue readloop
+			}
+			switch err := binproto.SendString(conn, s); err {
+			case nil:
+			case binproto.InvalidUTF8:
+				fmt.Fprintln(os.Stderr, err)
+				continue readloop
+			default:
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
 		case "number":
 			if len(parts) != 2 {
 				clientUsage()
@@ -208,6 +321,81 @@ readloop:
 				fmt.Fprintln(os.Stderr, err)
 				return 1
 			}
+		case "uint64":
+			if len(parts) != 2 {
+				clientUsage()
+				continue readloop
+			}
+			n, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 0, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not parse number: %s\n", err)
+				clientUsage()
+				continue readloop
+			}
+			if err := binproto.SendUint64(conn, n); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		case "bigint":
+			if len(parts) != 2 {
+				clientUsage()
+				continue readloop
+			}
+			n, ok := new(big.Int).SetString(strings.TrimSpace(parts[1]), 0)
+			if !ok {
+				fmt.Fprintln(os.Stderr, "Could not parse number")
+				clientUsage()
+				continue readloop
+			}
+			if err := binproto.SendBigInt(conn, n); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		case "float":
+			if len(parts) != 2 {
+				clientUsage()
+				continue readloop
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not parse float: %s\n", err)
+				clientUsage()
+				continue readloop
+			}
+			if err := binproto.SendFloat(conn, f); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		case "time":
+			if len(parts) != 2 {
+				clientUsage()
+				continue readloop
+			}
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(parts[1]))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not parse time: %s\n", err)
+				clientUsage()
+				continue readloop
+			}
+			if err := binproto.SendTime(conn, t); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		case "duration":
+			if len(parts) != 2 {
+				clientUsage()
+				continue readloop
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not parse duration: %s\n", err)
+				clientUsage()
+				continue readloop
+			}
+			if err := binproto.SendDuration(conn, d); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
 		case "list":
 			if err := binproto.InitList(conn); err != nil {
 				fmt.Fprintln(os.Stderr, err)
@@ -259,6 +447,21 @@ readloop:
 			}(strings.TrimSpace(parts[1])) {
 				return 1
 			}
+		case "array":
+			if len(parts) != 2 {
+				clientUsage()
+				continue readloop
+			}
+			arr, err := parseArray(strings.Fields(parts[1]))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not parse array: %s\n", err)
+				clientUsage()
+				continue readloop
+			}
+			if err := binproto.SendArray(conn, arr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
 		case "term":
 			if err := binproto.SendTerm(conn); err != nil {
 				fmt.Fprintln(os.Stderr, err)
@@ -1,42 +1,228 @@
 package binproto
 
 import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"github.com/silvasur/kagus"
+	"hash"
+	"hash/crc32"
 	"io"
-	"sync"
+	"math"
+	"unicode/utf8"
 )
 
+// A chunk with length 0 introduces a control chunk. Control chunks look like regular chunks, the first byte of the data is
+// the control code. Readers that don't know about control chunks will treat them as (meaningless) data, so they can still skip a stream.
+const (
+	ctrlHeader = 1 // Must be the first chunk of a stream. Followed by a flags byte and the fields enabled by the flags.
+	ctrlDigest = 2 // Sent before the terminator of a checksummed stream. Followed by the digest.
+	ctrlAbort  = 3 // Sent before the terminator of an aborted stream. Followed by the reason message.
+)
+
+// Flags of the ctrlHeader control chunk.
+const (
+	bsFlagCompressed = 1 << iota // The data is compressed with deflate.
+	bsFlagSize                   // The header contains the total length of the (uncompressed) data as int64.
+	bsFlagChecksum               // The header contains a ChecksumType byte, the stream ends with a ctrlDigest chunk.
+
+	bsKnownFlags = bsFlagCompressed | bsFlagSize | bsFlagChecksum
+)
+
+const maxControlLen = 4096
+
+// copyBufSize is the buffer size used by BinstreamReader.WriteTo and BinstreamWriter.ReadFrom.
+const copyBufSize = 64 * 1024
+
+// Errors of BinstreamReader and BinstreamWriter.
+var (
+	InvalidBinstream = errors.New("Invalid BinStream control data")
+	SizeMismatch     = errors.New("BinStream length differs from the declared size")
+	NegativeSize     = errors.New("Declared BinStream size is negative")
+	ChecksumMismatch = errors.New("BinStream checksum mismatch")
+	UnknownChecksum  = errors.New("Unknown checksum type")
+)
+
+// StreamAbortedError is returned by BinstreamReader, if the sender aborted the stream with BinstreamWriter.Abort.
+// The stream was terminated properly, so the connection can still be used.
+type StreamAbortedError struct {
+	Reason string // Message of the sender
+}
+
+func (e *StreamAbortedError) Error() string {
+	return "BinStream aborted by sender: " + e.Reason
+}
+
+// ChecksumType selects the digest that protects a BinStream.
+type ChecksumType byte
+
+// Possible ChecksumType values
+const (
+	ChecksumNone ChecksumType = iota
+	ChecksumCRC32
+	ChecksumSHA256
+)
+
+func (ct ChecksumType) newHash() (hash.Hash, error) {
+	switch ct {
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, UnknownChecksum
+}
+
+// BinstreamOptions configures a BinStream created by InitBinStreamWithOptions.
+type BinstreamOptions struct {
+	Compression int          // A compress/flate compression level. If not 0 (flate.NoCompression), the data gets compressed.
+	Size        int64        // If > 0 or HasSize is set, the total length of the data is announced to the reader. Close will fail, if the length differs. Must not be negative.
+	HasSize     bool         // Announce Size, even if it is 0 (an empty stream).
+	Checksum    ChecksumType // If not ChecksumNone, a digest of the data is sent at the end and verified by the reader.
+
+	// Data is buffered until at least MinChunkSize bytes can be sent in one chunk, Close sends the rest.
+	// Useful, if the data comes in many small writes.
+	MinChunkSize int
+
+	// Writes larger than MaxChunkSize bytes will be split into multiple chunks. 0 means no limit.
+	MaxChunkSize int
+}
+
 // BinstreamReader reads a binary stream from a binproto stream.
 type BinstreamReader struct {
-	r      io.Reader
-	err    error
-	toread int
-	surMu  *sync.Mutex // The mutex of the parent SimpleUnitReader
+	lazyPayload
+	r       io.Reader
+	err     error
+	toread  int
+	started bool          // Was the first chunk header read?
+	fr      io.ReadCloser // Decompressor, if the stream is compressed
+	size    int64         // Declared size, -1 if unknown
+	nread   int64
+	hash    hash.Hash // Hash of the read data, if the stream is checksummed
+	digest  []byte    // Received digest
+	aborted error     // Set, if the stream was aborted
 }
 
-// Read implements io.Reader.
-func (bsr *BinstreamReader) Read(p []byte) (int, error) {
-	if bsr.err != nil {
-		return 0, bsr.err
-	}
+func newBinstreamReader(r io.Reader) *BinstreamReader {
+	return &BinstreamReader{r: r, lazyPayload: newLazyPayload(), size: -1}
+}
 
-	if bsr.toread == 0 {
-		var _toread int32
-		if err := binary.Read(bsr.r, binary.LittleEndian, &_toread); err != nil {
-			bsr.err = err
-			return 0, err
+// fail stops reading the stream because of an error in the underlying stream.
+func (bsr *BinstreamReader) fail(err error) error {
+	bsr.err = err
+	bsr.broken = err
+	close(bsr.done)
+	return err
+}
+
+// rawReader reads the data of the chunks without decompressing them.
+type rawReader struct{ bsr *BinstreamReader }
+
+func (rr rawReader) Read(p []byte) (int, error) { return rr.bsr.readRaw(p) }
+
+// nextChunk reads chunk headers (handling control chunks) until a data chunk or the end of the stream was found.
+func (bsr *BinstreamReader) nextChunk() error {
+	for {
+		var l int32
+		if err := binary.Read(bsr.r, binary.LittleEndian, &l); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return bsr.fail(err)
 		}
 
-		if _toread < 0 {
+		if l < 0 {
+			bsr.started = true
 			bsr.toread = -1
 			bsr.err = io.EOF
-			bsr.surMu.Unlock() // TODO: Unlock on other conditions?
-			return 0, io.EOF
+			if bsr.aborted != nil {
+				bsr.err = bsr.aborted
+			}
+			close(bsr.done)
+			return bsr.err
+		}
+
+		if l > 0 {
+			bsr.started = true
+			bsr.toread = int(l)
+			return nil
+		}
+
+		if err := bsr.readControl(); err != nil {
+			return bsr.fail(err)
+		}
+	}
+}
+
+func (bsr *BinstreamReader) readControl() error {
+	var l int32
+	if err := binary.Read(bsr.r, binary.LittleEndian, &l); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if l <= 0 || l > maxControlLen {
+		return InvalidBinstream
+	}
+
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(bsr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+		return err
+	}
 
-		bsr.toread = int(_toread)
+	switch buf[0] {
+	case ctrlHeader:
+		if bsr.started || len(buf) < 2 {
+			return InvalidBinstream
+		}
+		flags := buf[1]
+		if flags&^bsKnownFlags != 0 {
+			return InvalidBinstream
+		}
+		hdr := bytes.NewReader(buf[2:])
+		if flags&bsFlagSize != 0 {
+			if err := binary.Read(hdr, binary.LittleEndian, &bsr.size); err != nil || bsr.size < 0 {
+				return InvalidBinstream
+			}
+		}
+		if flags&bsFlagChecksum != 0 {
+			ct, err := hdr.ReadByte()
+			if err != nil {
+				return InvalidBinstream
+			}
+			if bsr.hash, err = ChecksumType(ct).newHash(); err != nil {
+				return InvalidBinstream
+			}
+		}
+		if flags&bsFlagCompressed != 0 {
+			bsr.fr = flate.NewReader(rawReader{bsr})
+		}
+	case ctrlDigest:
+		bsr.digest = buf[1:]
+	case ctrlAbort:
+		bsr.aborted = &StreamAbortedError{string(buf[1:])}
+	}
+	// Unknown control chunks are ignored, so future extensions stay compatible.
+
+	bsr.started = true
+	return nil
+}
+
+func (bsr *BinstreamReader) readRaw(p []byte) (int, error) {
+	if bsr.err != nil {
+		return 0, bsr.err
+	}
+
+	if bsr.toread == 0 {
+		if err := bsr.nextChunk(); err != nil {
+			return 0, err
+		}
 	}
 
 	want := len(p)
@@ -51,52 +237,301 @@ func (bsr *BinstreamReader) Read(p []byte) (int, error) {
 		return n, nil
 	case io.EOF:
 		// NOTE: Perhaps we should log this? IDK...
-		bsr.err = errors.New("binstream terminated abnormally")
-		return n, bsr.err
+		return n, bsr.fail(errors.New("binstream terminated abnormally"))
 	}
 
-	bsr.err = err
+	return n, bsr.fail(err)
+}
+
+func (bsr *BinstreamReader) skipRaw() error {
+	nirvana := kagus.NewNirvanaWriter()
+	_, err := io.Copy(nirvana, rawReader{bsr})
+	if _, ok := err.(*StreamAbortedError); ok {
+		return nil // The stream was terminated properly
+	}
+	return err
+}
+
+// Size returns the declared length of the stream, if the sender announced it.
+// The size is known after the first call of Read.
+func (bsr *BinstreamReader) Size() (int64, bool) {
+	return bsr.size, bsr.size >= 0
+}
+
+// Read implements io.Reader.
+//
+// If the sender declared a size or sent a checksum, they are verified at the end of the stream.
+// In case of a mismatch, SizeMismatch or ChecksumMismatch is returned instead of io.EOF.
+func (bsr *BinstreamReader) Read(p []byte) (int, error) {
+	n, err := bsr.read(p)
+	bsr.nread += int64(n)
+	if bsr.hash != nil {
+		bsr.hash.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		if bsr.size >= 0 && bsr.nread != bsr.size {
+			return n, SizeMismatch
+		}
+		if bsr.hash != nil && !bytes.Equal(bsr.hash.Sum(nil), bsr.digest) {
+			return n, ChecksumMismatch
+		}
+	}
+	return n, err
+}
+
+func (bsr *BinstreamReader) read(p []byte) (int, error) {
+	if !bsr.started && bsr.err == nil {
+		// The first chunk might be a header that tells us how to read the stream.
+		if err := bsr.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	if bsr.fr == nil {
+		return bsr.readRaw(p)
+	}
+
+	n, err := bsr.fr.Read(p)
+	switch {
+	case err == io.EOF:
+		// The compressed data ended, the terminator is still left in the stream.
+		if err := bsr.skipRaw(); err != nil {
+			return n, err
+		}
+	case err != nil && bsr.err != nil && bsr.err != io.EOF:
+		// Report errors of the underlying stream directly.
+		err = bsr.err
+	}
 	return n, err
 }
 
 // FastForward skips to the end of the stream. Use this, if the data is useless.
+// The size and checksum of the stream will not be verified and an aborted stream is not considered an error.
 func (bsr *BinstreamReader) FastForward() error {
-	nirvana := kagus.NewNirvanaWriter()
-	_, err := io.Copy(nirvana, bsr)
-	return err
+	// No need to decompress the data, we can skip the raw chunks.
+	return bsr.skipRaw()
+}
+
+// Close implements io.Closer. It skips the rest of the stream (see FastForward), so the parent SimpleUnitReader can be used again.
+// If that fails, the position in the underlying stream is lost and the parent SimpleUnitReader will return the error from now on.
+func (bsr *BinstreamReader) Close() error {
+	return bsr.FastForward()
+}
+
+// WriteTo implements io.WriterTo, so io.Copy will use a large buffer.
+func (bsr *BinstreamReader) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, copyBufSize)
+	var written int64
+	for {
+		n, err := bsr.Read(buf)
+		if n > 0 {
+			nw, werr := w.Write(buf[:n])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF:
+			return written, nil
+		default:
+			return written, err
+		}
+	}
 }
 
 // BinstreamReader writes a binary stream to a binproto stream.
 type BinstreamWriter struct {
-	w   io.Writer
-	err error
+	w        io.Writer
+	err      error
+	fw       *flate.Writer // Compressor, if the stream is compressed
+	size     int64         // Declared size, -1 if unknown
+	nwritten int64
+	checksum ChecksumType
+	hash     hash.Hash
+	minChunk int
+	maxChunk int
+	buf      []byte // Data waiting for MinChunkSize
 }
 
-// Write implements io.Writer.
-func (bsw *BinstreamWriter) Write(p []byte) (int, error) {
+// rawWriter writes chunks without compressing them.
+type rawWriter struct{ bsw *BinstreamWriter }
+
+func (rw rawWriter) Write(p []byte) (int, error) { return rw.bsw.writeChunk(p) }
+
+func newBinstreamWriter(w io.Writer, opts BinstreamOptions) (*BinstreamWriter, error) {
+	bsw := &BinstreamWriter{
+		w:        w,
+		size:     -1,
+		checksum: opts.Checksum,
+		minChunk: opts.MinChunkSize,
+		maxChunk: math.MaxInt32}
+
+	if opts.MaxChunkSize > 0 && opts.MaxChunkSize < bsw.maxChunk {
+		bsw.maxChunk = opts.MaxChunkSize
+	}
+
+	if opts.Size < 0 {
+		return nil, NegativeSize
+	}
+	if opts.Size > 0 || opts.HasSize {
+		bsw.size = opts.Size
+	}
+
+	if opts.Checksum != ChecksumNone {
+		h, err := opts.Checksum.newHash()
+		if err != nil {
+			return nil, err
+		}
+		bsw.hash = h
+	}
+
+	if opts.Compression != flate.NoCompression {
+		fw, err := flate.NewWriter(rawWriter{bsw}, opts.Compression)
+		if err != nil {
+			return nil, err
+		}
+		bsw.fw = fw
+	}
+
+	return bsw, nil
+}
+
+// writeHeader writes the header control chunk, if the options need one.
+func (bsw *BinstreamWriter) writeHeader() error {
+	var flags byte
+	buf := new(bytes.Buffer)
+	if bsw.fw != nil {
+		flags |= bsFlagCompressed
+	}
+	if bsw.size >= 0 {
+		flags |= bsFlagSize
+		binary.Write(buf, binary.LittleEndian, bsw.size)
+	}
+	if bsw.hash != nil {
+		flags |= bsFlagChecksum
+		buf.WriteByte(byte(bsw.checksum))
+	}
+
+	if flags == 0 {
+		return nil
+	}
+	return bsw.writeControl(append([]byte{ctrlHeader, flags}, buf.Bytes()...))
+}
+
+func (bsw *BinstreamWriter) writeControl(payload []byte) error {
+	if err := binary.Write(bsw.w, binary.LittleEndian, [2]int32{0, int32(len(payload))}); err != nil {
+		return err
+	}
+	_, err := bsw.w.Write(payload)
+	return err
+}
+
+// writeChunk buffers p or sends it in chunks, according to the chunk size limits.
+func (bsw *BinstreamWriter) writeChunk(p []byte) (int, error) {
 	if bsw.err != nil {
 		return 0, bsw.err
 	}
 
-	l := len(p)
-	if l == 0 {
-		return 0, nil
+	if len(bsw.buf) == 0 && len(p) >= bsw.minChunk {
+		return bsw.sendChunks(p)
 	}
 
-	if err := binary.Write(bsw.w, binary.LittleEndian, int32(l)); err != nil {
-		bsw.err = err
-		return 0, err
+	bsw.buf = append(bsw.buf, p...)
+	if len(bsw.buf) >= bsw.minChunk {
+		if err := bsw.flushBuf(); err != nil {
+			return 0, err
+		}
 	}
+	return len(p), nil
+}
 
-	n, err := bsw.w.Write(p)
-	if err != nil {
-		bsw.err = err
+func (bsw *BinstreamWriter) flushBuf() error {
+	if len(bsw.buf) == 0 {
+		return nil
 	}
 
-	return n, err
+	_, err := bsw.sendChunks(bsw.buf)
+	bsw.buf = bsw.buf[:0]
+	return err
+}
+
+func (bsw *BinstreamWriter) sendChunks(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		l := len(p)
+		if l > bsw.maxChunk {
+			l = bsw.maxChunk
+		}
+
+		if err := binary.Write(bsw.w, binary.LittleEndian, int32(l)); err != nil {
+			bsw.err = err
+			return written, err
+		}
+
+		n, err := bsw.w.Write(p[:l])
+		written += n
+		if err != nil {
+			bsw.err = err
+			return written, err
+		}
+		p = p[l:]
+	}
+
+	return written, nil
+}
+
+// Write implements io.Writer.
+func (bsw *BinstreamWriter) Write(p []byte) (n int, err error) {
+	if bsw.fw == nil {
+		n, err = bsw.writeChunk(p)
+	} else if bsw.err != nil {
+		return 0, bsw.err
+	} else {
+		n, err = bsw.fw.Write(p)
+	}
+
+	bsw.nwritten += int64(n)
+	if bsw.hash != nil {
+		bsw.hash.Write(p[:n])
+	}
+	return
+}
+
+// ReadFrom implements io.ReaderFrom, so io.Copy will use a large buffer.
+func (bsw *BinstreamWriter) ReadFrom(r io.Reader) (int64, error) {
+	size := copyBufSize
+	if bsw.maxChunk < size {
+		size = bsw.maxChunk
+	}
+	buf := make([]byte, size)
+
+	var read int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			read += int64(n)
+			if _, werr := bsw.Write(buf[:n]); werr != nil {
+				return read, werr
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF:
+			return read, nil
+		default:
+			return read, err
+		}
+	}
 }
 
 // Close implements io.Closer. You MUST close a stream, so it is terminated properly.
+//
+// If a size was declared and a different amount of data was written, the stream is terminated anyway and SizeMismatch is returned.
 func (bsw *BinstreamWriter) Close() error {
 	switch bsw.err {
 	case nil:
@@ -105,7 +540,60 @@ func (bsw *BinstreamWriter) Close() error {
 	default:
 		return bsw.err
 	}
+	if bsw.fw != nil {
+		if err := bsw.fw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := bsw.flushBuf(); err != nil {
+		return err
+	}
+	if bsw.hash != nil {
+		if err := bsw.writeControl(append([]byte{ctrlDigest}, bsw.hash.Sum(nil)...)); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(bsw.w, binary.LittleEndian, int32(-1)); err != nil {
+		return err
+	}
+
+	bsw.err = io.EOF
+	if bsw.size >= 0 && bsw.nwritten != bsw.size {
+		return SizeMismatch
+	}
+	return nil
+}
+
+// Abort terminates the stream without finishing it, e.g. because the data source failed.
+// The reader will get a *StreamAbortedError containing the message of reason instead of io.EOF.
+func (bsw *BinstreamWriter) Abort(reason error) error {
+	switch bsw.err {
+	case nil:
+	case io.EOF:
+		return nil
+	default:
+		return bsw.err
+	}
+
+	msg := []byte("unknown reason")
+	if reason != nil {
+		msg = []byte(reason.Error())
+	}
+	if len(msg) >= maxControlLen {
+		cut := maxControlLen - 1
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut-- // Don't split a UTF-8 sequence
+		}
+		msg = msg[:cut]
+	}
+
+	// The compressor and buffer will not be flushed, the reader discards the incomplete data anyway.
+	if err := bsw.writeControl(append([]byte{ctrlAbort}, msg...)); err != nil {
+		bsw.err = err
+		return err
+	}
 	if err := binary.Write(bsw.w, binary.LittleEndian, int32(-1)); err != nil {
+		bsw.err = err
 		return err
 	}
 
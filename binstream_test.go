@@ -0,0 +1,348 @@
+package binproto
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// oldFastForward skips a BinStream the way readers without knowledge of the extensions do.
+// The number of chunks is returned.
+func oldFastForward(r io.Reader) (int, error) {
+	chunks := 0
+	for {
+		var l int32
+		if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+			return chunks, err
+		}
+		if l < 0 {
+			return chunks, nil
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, int64(l)); err != nil {
+			return chunks, err
+		}
+		chunks++
+	}
+}
+
+func writeStream(t *testing.T, w io.Writer, opts BinstreamOptions, chunks ...[]byte) {
+	bsw, err := InitBinStreamWithOptions(w, opts)
+	if err != nil {
+		t.Fatalf("Could not init a BinstreamWriter: %s", err)
+	}
+	for _, chunk := range chunks {
+		if _, err := bsw.Write(chunk); err != nil {
+			t.Fatalf("Could not write chunk to bsw: %s", err)
+		}
+	}
+	if err := bsw.Close(); err != nil {
+		t.Fatalf("Could not close bsw: %s", err)
+	}
+}
+
+func TestCompressedBinstream(t *testing.T) {
+	content := bytes.Repeat([]byte("2016-08-16 12:00:00 INFO all is fine\n"), 1000)
+
+	w := new(bytes.Buffer)
+	writeStream(t, w, BinstreamOptions{Compression: flate.BestCompression}, content[:1000], content[1000:])
+	chkerr(t, SendNumber(w, 23), "SendNumber")
+
+	if w.Len() >= len(content)/10 {
+		t.Errorf("Stream was not compressed, it has %d bytes", w.Len())
+	}
+
+	raw := w.Bytes()
+
+	ur := NewSimpleUnitReader(bytes.NewReader(raw))
+	d, err := ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader))
+	if err != nil {
+		t.Fatalf("BinstreamReader failed: %s", err)
+	}
+	if !bytes.Equal(d, content) {
+		t.Errorf("Wrong Binstream data, got %d bytes", len(d))
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 23 {
+		t.Errorf("Wrong number after stream: %d", n)
+	}
+
+	ur = NewSimpleUnitReader(bytes.NewReader(raw))
+	if err := SkipNext(ur); err != nil {
+		t.Fatalf("Skipping failed: %s", err)
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 23 {
+		t.Errorf("Wrong number after skipped stream: %d", n)
+	}
+
+	r := bytes.NewReader(raw[1:])
+	if _, err := oldFastForward(r); err != nil {
+		t.Fatalf("Old style skipping failed: %s", err)
+	}
+	if r.Len() != 9 {
+		t.Errorf("Old style skipping stopped at the wrong position, %d bytes left", r.Len())
+	}
+}
+
+func TestChecksummedBinstream(t *testing.T) {
+	content := []byte("hello, world!")
+
+	for _, ct := range []ChecksumType{ChecksumCRC32, ChecksumSHA256} {
+		w := new(bytes.Buffer)
+		writeStream(t, w, BinstreamOptions{Size: int64(len(content)), Checksum: ct}, content[:5], content[5:])
+		raw := w.Bytes()
+
+		ur := NewSimpleUnitReader(bytes.NewReader(raw))
+		bsr := readExpect2(t, ur, UTBinStream).(*BinstreamReader)
+		d, err := ioutil.ReadAll(bsr)
+		if err != nil {
+			t.Fatalf("BinstreamReader failed: %s", err)
+		}
+		if !bytes.Equal(d, content) {
+			t.Errorf("Wrong Binstream data: %v", d)
+		}
+		if size, ok := bsr.Size(); !ok || size != int64(len(content)) {
+			t.Errorf("Wrong size: %d, %v", size, ok)
+		}
+
+		// Corrupt the last byte of the data, it is located right before the digest control chunk.
+		hashLen := 4
+		if ct == ChecksumSHA256 {
+			hashLen = 32
+		}
+		raw[len(raw)-4-1-hashLen-8-1] ^= 0xff
+
+		ur = NewSimpleUnitReader(bytes.NewReader(raw))
+		if _, err := ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader)); err != ChecksumMismatch {
+			t.Errorf("Expected ChecksumMismatch, got: %v", err)
+		}
+	}
+}
+
+func TestBinstreamSizeMismatch(t *testing.T) {
+	w := new(bytes.Buffer)
+	bsw, err := InitBinStreamWithOptions(w, BinstreamOptions{Size: 100})
+	if err != nil {
+		t.Fatalf("Could not init a BinstreamWriter: %s", err)
+	}
+	if _, err := bsw.Write([]byte("truncated")); err != nil {
+		t.Fatalf("Could not write chunk to bsw: %s", err)
+	}
+	if err := bsw.Close(); err != SizeMismatch {
+		t.Errorf("Expected SizeMismatch from Close, got: %v", err)
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if _, err := ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader)); err != SizeMismatch {
+		t.Errorf("Expected SizeMismatch, got: %v", err)
+	}
+}
+
+func TestAbortedBinstream(t *testing.T) {
+	for _, opts := range []BinstreamOptions{{}, {Compression: flate.DefaultCompression, Checksum: ChecksumCRC32}} {
+		w := new(bytes.Buffer)
+		bsw, err := InitBinStreamWithOptions(w, opts)
+		if err != nil {
+			t.Fatalf("Could not init a BinstreamWriter: %s", err)
+		}
+		if _, err := bsw.Write([]byte("partial data")); err != nil {
+			t.Fatalf("Could not write chunk to bsw: %s", err)
+		}
+		if err := bsw.Abort(errors.New("disk on fire")); err != nil {
+			t.Fatalf("Could not abort bsw: %s", err)
+		}
+		if err := bsw.Close(); err != nil {
+			t.Errorf("Close after Abort failed: %s", err)
+		}
+		chkerr(t, SendNumber(w, 42), "SendNumber")
+
+		ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+		_, err = ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader))
+		aborted, ok := err.(*StreamAbortedError)
+		if !ok {
+			t.Fatalf("Expected a *StreamAbortedError, got: %v", err)
+		}
+		if aborted.Reason != "disk on fire" {
+			t.Errorf("Wrong reason: %s", aborted.Reason)
+		}
+		if n := readExpect2(t, ur, UTNumber).(int64); n != 42 {
+			t.Errorf("Wrong number after stream: %d", n)
+		}
+
+		ur = NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+		if err := SkipNext(ur); err != nil {
+			t.Fatalf("Skipping failed: %s", err)
+		}
+		if n := readExpect2(t, ur, UTNumber).(int64); n != 42 {
+			t.Errorf("Wrong number after skipped stream: %d", n)
+		}
+	}
+}
+
+func TestBinstreamChunking(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+
+	w := new(bytes.Buffer)
+	bsw, err := InitBinStreamWithOptions(w, BinstreamOptions{MinChunkSize: 300})
+	if err != nil {
+		t.Fatalf("Could not init a BinstreamWriter: %s", err)
+	}
+	for _, b := range content {
+		if _, err := bsw.Write([]byte{b}); err != nil {
+			t.Fatalf("Could not write to bsw: %s", err)
+		}
+	}
+	if err := bsw.Close(); err != nil {
+		t.Fatalf("Could not close bsw: %s", err)
+	}
+	if chunks, err := oldFastForward(bytes.NewReader(w.Bytes()[1:])); err != nil || chunks != 4 {
+		t.Errorf("Expected 4 chunks, got %d (err: %v)", chunks, err)
+	}
+
+	w = new(bytes.Buffer)
+	bsw, err = InitBinStreamWithOptions(w, BinstreamOptions{MaxChunkSize: 100})
+	if err != nil {
+		t.Fatalf("Could not init a BinstreamWriter: %s", err)
+	}
+	if _, err := io.Copy(bsw, io.LimitReader(bytes.NewReader(content), int64(len(content)))); err != nil {
+		t.Fatalf("Could not copy to bsw: %s", err)
+	}
+	if err := bsw.Close(); err != nil {
+		t.Fatalf("Could not close bsw: %s", err)
+	}
+	if chunks, err := oldFastForward(bytes.NewReader(w.Bytes()[1:])); err != nil || chunks != 10 {
+		t.Errorf("Expected 10 chunks, got %d (err: %v)", chunks, err)
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	out := new(bytes.Buffer)
+	if _, err := io.Copy(out, readExpect2(t, ur, UTBinStream).(*BinstreamReader)); err != nil {
+		t.Fatalf("Could not copy from BinstreamReader: %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Errorf("Wrong Binstream data: %v", out.Bytes())
+	}
+}
+
+func TestBinstreamLifecycle(t *testing.T) {
+	w := new(bytes.Buffer)
+	writeStream(t, w, BinstreamOptions{}, []byte("hello"), []byte("world"))
+	chkerr(t, SendNumber(w, 1), "SendNumber")
+	writeStream(t, w, BinstreamOptions{}, []byte("hello"), []byte("world"))
+	chkerr(t, SendNumber(w, 2), "SendNumber")
+	raw := w.Bytes()
+
+	ur := NewSimpleUnitReader(bytes.NewReader(raw))
+	bsr := readExpect2(t, ur, UTBinStream).(*BinstreamReader)
+	if _, _, err := ur.ReadUnit(); err != ErrStreamOpen {
+		t.Errorf("Expected ErrStreamOpen, got: %v", err)
+	}
+	if _, err := bsr.Read(make([]byte, 3)); err != nil {
+		t.Fatalf("Could not read from stream: %s", err)
+	}
+	if err := bsr.Close(); err != nil {
+		t.Fatalf("Could not close stream: %s", err)
+	}
+	select {
+	case <-bsr.Done():
+	default:
+		t.Error("Done channel not closed")
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 1 {
+		t.Errorf("Wrong number after stream: %d", n)
+	}
+	if _, err := ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader)); err != nil {
+		t.Fatalf("Could not read stream: %s", err)
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 2 {
+		t.Errorf("Wrong number after stream: %d", n)
+	}
+
+	// Truncate the data in the middle of the first stream.
+	ur = NewSimpleUnitReader(bytes.NewReader(raw[:8]))
+	bsr = readExpect2(t, ur, UTBinStream).(*BinstreamReader)
+	if _, err := ioutil.ReadAll(bsr); err == nil {
+		t.Fatal("Reading a truncated stream succeeded")
+	}
+	if err := bsr.Close(); err == nil {
+		t.Error("Closing a broken stream succeeded")
+	}
+	if _, _, err := ur.ReadUnit(); err == nil || err == ErrStreamOpen {
+		t.Errorf("Expected the error of the broken stream, got: %v", err)
+	}
+}
+
+func TestTruncatedControlChunk(t *testing.T) {
+	w := new(bytes.Buffer)
+	writeStream(t, w, BinstreamOptions{Compression: flate.BestSpeed}, []byte("hello"))
+	raw := w.Bytes()
+
+	// Type byte + control chunk marker, then cut before and after the length of the control chunk.
+	for _, n := range []int{5, 9} {
+		ur := NewSimpleUnitReader(bytes.NewReader(raw[:n]))
+		if _, err := ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader)); err != io.ErrUnexpectedEOF {
+			t.Errorf("Truncated after %d bytes: expected io.ErrUnexpectedEOF, got: %v", n, err)
+		}
+	}
+}
+
+func TestEmptyDeclaredSize(t *testing.T) {
+	w := new(bytes.Buffer)
+	writeStream(t, w, BinstreamOptions{HasSize: true})
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	bsr := readExpect2(t, ur, UTBinStream).(*BinstreamReader)
+	if d, err := ioutil.ReadAll(bsr); err != nil || len(d) != 0 {
+		t.Fatalf("Unexpected data: %v (err: %v)", d, err)
+	}
+	if size, ok := bsr.Size(); !ok || size != 0 {
+		t.Errorf("Wrong size: %d, %v", size, ok)
+	}
+
+	// Data in a stream that was declared empty
+	w.Reset()
+	bsw, err := InitBinStreamWithOptions(w, BinstreamOptions{HasSize: true})
+	if err != nil {
+		t.Fatalf("Could not init a BinstreamWriter: %s", err)
+	}
+	if _, err := bsw.Write([]byte("data")); err != nil {
+		t.Fatalf("Could not write to bsw: %s", err)
+	}
+	if err := bsw.Close(); err != SizeMismatch {
+		t.Errorf("Expected SizeMismatch from Close, got: %v", err)
+	}
+	ur = NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if _, err := ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader)); err != SizeMismatch {
+		t.Errorf("Expected SizeMismatch, got: %v", err)
+	}
+
+	for _, opts := range []BinstreamOptions{{Size: -1, HasSize: true}, {Size: -1}} {
+		if _, err := InitBinStreamWithOptions(w, opts); err != NegativeSize {
+			t.Errorf("%+v: expected NegativeSize, got: %v", opts, err)
+		}
+	}
+}
+
+func TestAbortLongReason(t *testing.T) {
+	reason := strings.Repeat("ä", maxControlLen) // 2 bytes per rune, the limit is in the middle of one
+	w := new(bytes.Buffer)
+	bsw, err := InitBinStreamWithOptions(w, BinstreamOptions{})
+	if err != nil {
+		t.Fatalf("Could not init a BinstreamWriter: %s", err)
+	}
+	chkerr(t, bsw.Abort(errors.New(reason)), "Abort")
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	_, err = ioutil.ReadAll(readExpect2(t, ur, UTBinStream).(*BinstreamReader))
+	aborted, ok := err.(*StreamAbortedError)
+	if !ok {
+		t.Fatalf("Expected a *StreamAbortedError, got: %v", err)
+	}
+	if !utf8.ValidString(aborted.Reason) || !strings.HasPrefix(reason, aborted.Reason) || len(aborted.Reason) != maxControlLen-2 {
+		t.Errorf("Wrong truncation, got %d bytes", len(aborted.Reason))
+	}
+}
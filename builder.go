@@ -0,0 +1,364 @@
+package binproto
+
+import (
+	"io"
+	"math/big"
+	"time"
+)
+
+// Builder writes messages using the Send* functions.
+//
+// Lists and KVMaps are filled in closures, the Term is sent automatically when the closure returns.
+// The first error is stored and all following calls do nothing, so you only need to check Err at the end:
+//
+//	b := NewBuilder(w)
+//	b.Request(42).IdKVMap(func(m *IdKVMapBuilder) {
+//	    m.Bin(16, []byte("hi"))
+//	    m.List(2, func(l *Builder) { l.Number(1).Number(2) })
+//	})
+//	if err := b.Err(); err != nil { ... }
+type Builder struct {
+	w   io.Writer
+	err error
+}
+
+// NewBuilder creates a Builder writing to w.
+func NewBuilder(w io.Writer) *Builder {
+	return &Builder{w: w}
+}
+
+// Err returns the first error that occurred.
+func (b *Builder) Err() error {
+	return b.err
+}
+
+func (b *Builder) do(f func(w io.Writer) error) *Builder {
+	if b.err == nil {
+		b.err = f(b.w)
+	}
+	return b
+}
+
+// Flush flushes the underlying writer, see FlushMessage.
+func (b *Builder) Flush() *Builder {
+	return b.do(FlushMessage)
+}
+
+// Request starts a request message, the value must follow.
+func (b *Builder) Request(code uint16) *Builder {
+	return b.do(func(w io.Writer) error { return InitRequest(w, code) })
+}
+
+// Answer starts an answer message, the value must follow.
+func (b *Builder) Answer(code uint16) *Builder {
+	return b.do(func(w io.Writer) error { return InitAnswer(w, code) })
+}
+
+// Event starts an event message, the value must follow.
+func (b *Builder) Event(code uint16) *Builder {
+	return b.do(func(w io.Writer) error { return InitEvent(w, code) })
+}
+
+// Nil sends a Nil unit.
+func (b *Builder) Nil() *Builder {
+	return b.do(SendNil)
+}
+
+// Bin sends a Bin unit.
+func (b *Builder) Bin(data []byte) *Builder {
+	return b.do(func(w io.Writer) error { return SendBin(w, data) })
+}
+
+// String sends a String unit.
+func (b *Builder) String(s string) *Builder {
+	return b.do(func(w io.Writer) error { return SendString(w, s) })
+}
+
+// Number sends a Number unit.
+func (b *Builder) Number(n int64) *Builder {
+	return b.do(func(w io.Writer) error { return SendNumber(w, n) })
+}
+
+// Uint64 sends a Uint64 unit.
+func (b *Builder) Uint64(n uint64) *Builder {
+	return b.do(func(w io.Writer) error { return SendUint64(w, n) })
+}
+
+// BigInt sends a BigInt unit.
+func (b *Builder) BigInt(n *big.Int) *Builder {
+	return b.do(func(w io.Writer) error { return SendBigInt(w, n) })
+}
+
+// Float sends a Float unit.
+func (b *Builder) Float(f float64) *Builder {
+	return b.do(func(w io.Writer) error { return SendFloat(w, f) })
+}
+
+// Time sends a Time unit.
+func (b *Builder) Time(t time.Time) *Builder {
+	return b.do(func(w io.Writer) error { return SendTime(w, t) })
+}
+
+// Duration sends a Duration unit.
+func (b *Builder) Duration(d time.Duration) *Builder {
+	return b.do(func(w io.Writer) error { return SendDuration(w, d) })
+}
+
+// Array sends an Array unit, see SendArray.
+func (b *Builder) Array(data interface{}) *Builder {
+	return b.do(func(w io.Writer) error { return SendArray(w, data) })
+}
+
+// Sized sends a Sized unit, fn must add exactly one List, TextKVMap or IdKVMap to the passed Builder.
+// The container is buffered until fn returns, see BeginSized.
+func (b *Builder) Sized(fn func(s *Builder)) *Builder {
+	return b.do(func(w io.Writer) error {
+		sw := BeginSized(w)
+		s := NewBuilder(sw)
+		fn(s)
+		if s.err != nil {
+			return s.err
+		}
+		return sw.Close()
+	})
+}
+
+// Bool sends a Bool unit.
+func (b *Builder) Bool(v bool) *Builder {
+	return b.do(func(w io.Writer) error { return SendBool(w, v) })
+}
+
+// Byte sends a Byte unit.
+func (b *Builder) Byte(v byte) *Builder {
+	return b.do(func(w io.Writer) error { return SendByte(w, v) })
+}
+
+// List sends a List, fn adds the items.
+func (b *Builder) List(fn func(l *Builder)) *Builder {
+	b.do(InitList)
+	if b.err == nil {
+		fn(b)
+	}
+	return b.do(SendTerm)
+}
+
+// IdKVMap sends an IdKVMap, fn adds the pairs.
+func (b *Builder) IdKVMap(fn func(m *IdKVMapBuilder)) *Builder {
+	b.do(InitIdKVMap)
+	if b.err == nil {
+		fn(&IdKVMapBuilder{b})
+	}
+	return b.do(SendTerm)
+}
+
+// TextKVMap sends a TextKVMap, fn adds the pairs.
+func (b *Builder) TextKVMap(fn func(m *TextKVMapBuilder)) *Builder {
+	b.do(InitTextKVMap)
+	if b.err == nil {
+		fn(&TextKVMapBuilder{b})
+	}
+	return b.do(SendTerm)
+}
+
+// BinStream sends a BinStream, fn writes the data. The stream is closed when fn returns.
+// If fn returns an error, the stream is aborted (see BinstreamWriter.Abort) and the error is stored.
+func (b *Builder) BinStream(opts BinstreamOptions, fn func(bsw *BinstreamWriter) error) *Builder {
+	return b.do(func(w io.Writer) error {
+		bsw, err := InitBinStreamWithOptions(w, opts)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(bsw); err != nil {
+			if aerr := bsw.Abort(err); aerr != nil {
+				return aerr
+			}
+			return err
+		}
+		return bsw.Close()
+	})
+}
+
+// IdKVMapBuilder adds pairs to an IdKVMap. Every method sends the UKey and the value.
+type IdKVMapBuilder struct{ b *Builder }
+
+func (m *IdKVMapBuilder) key(k byte) *Builder {
+	return m.b.do(func(w io.Writer) error { return SendUKey(w, k) })
+}
+
+func (m *IdKVMapBuilder) Nil(key byte) *IdKVMapBuilder {
+	m.key(key).Nil()
+	return m
+}
+
+func (m *IdKVMapBuilder) Bin(key byte, data []byte) *IdKVMapBuilder {
+	m.key(key).Bin(data)
+	return m
+}
+
+func (m *IdKVMapBuilder) String(key byte, s string) *IdKVMapBuilder {
+	m.key(key).String(s)
+	return m
+}
+
+func (m *IdKVMapBuilder) Number(key byte, n int64) *IdKVMapBuilder {
+	m.key(key).Number(n)
+	return m
+}
+
+func (m *IdKVMapBuilder) Uint64(key byte, n uint64) *IdKVMapBuilder {
+	m.key(key).Uint64(n)
+	return m
+}
+
+func (m *IdKVMapBuilder) BigInt(key byte, n *big.Int) *IdKVMapBuilder {
+	m.key(key).BigInt(n)
+	return m
+}
+
+func (m *IdKVMapBuilder) Float(key byte, f float64) *IdKVMapBuilder {
+	m.key(key).Float(f)
+	return m
+}
+
+func (m *IdKVMapBuilder) Time(key byte, t time.Time) *IdKVMapBuilder {
+	m.key(key).Time(t)
+	return m
+}
+
+func (m *IdKVMapBuilder) Duration(key byte, d time.Duration) *IdKVMapBuilder {
+	m.key(key).Duration(d)
+	return m
+}
+
+func (m *IdKVMapBuilder) Array(key byte, data interface{}) *IdKVMapBuilder {
+	m.key(key).Array(data)
+	return m
+}
+
+func (m *IdKVMapBuilder) Sized(key byte, fn func(s *Builder)) *IdKVMapBuilder {
+	m.key(key).Sized(fn)
+	return m
+}
+
+func (m *IdKVMapBuilder) Bool(key byte, v bool) *IdKVMapBuilder {
+	m.key(key).Bool(v)
+	return m
+}
+
+func (m *IdKVMapBuilder) Byte(key byte, v byte) *IdKVMapBuilder {
+	m.key(key).Byte(v)
+	return m
+}
+
+func (m *IdKVMapBuilder) List(key byte, fn func(l *Builder)) *IdKVMapBuilder {
+	m.key(key).List(fn)
+	return m
+}
+
+func (m *IdKVMapBuilder) IdKVMap(key byte, fn func(m *IdKVMapBuilder)) *IdKVMapBuilder {
+	m.key(key).IdKVMap(fn)
+	return m
+}
+
+func (m *IdKVMapBuilder) TextKVMap(key byte, fn func(m *TextKVMapBuilder)) *IdKVMapBuilder {
+	m.key(key).TextKVMap(fn)
+	return m
+}
+
+func (m *IdKVMapBuilder) BinStream(key byte, opts BinstreamOptions, fn func(bsw *BinstreamWriter) error) *IdKVMapBuilder {
+	m.key(key).BinStream(opts, fn)
+	return m
+}
+
+// TextKVMapBuilder adds pairs to a TextKVMap. Every method sends the key and the value.
+type TextKVMapBuilder struct{ b *Builder }
+
+func (m *TextKVMapBuilder) key(k string) *Builder {
+	return m.b.do(func(w io.Writer) error { return SendTextKey(w, k) })
+}
+
+func (m *TextKVMapBuilder) Nil(key string) *TextKVMapBuilder {
+	m.key(key).Nil()
+	return m
+}
+
+func (m *TextKVMapBuilder) Bin(key string, data []byte) *TextKVMapBuilder {
+	m.key(key).Bin(data)
+	return m
+}
+
+func (m *TextKVMapBuilder) String(key string, s string) *TextKVMapBuilder {
+	m.key(key).String(s)
+	return m
+}
+
+func (m *TextKVMapBuilder) Number(key string, n int64) *TextKVMapBuilder {
+	m.key(key).Number(n)
+	return m
+}
+
+func (m *TextKVMapBuilder) Uint64(key string, n uint64) *TextKVMapBuilder {
+	m.key(key).Uint64(n)
+	return m
+}
+
+func (m *TextKVMapBuilder) BigInt(key string, n *big.Int) *TextKVMapBuilder {
+	m.key(key).BigInt(n)
+	return m
+}
+
+func (m *TextKVMapBuilder) Float(key string, f float64) *TextKVMapBuilder {
+	m.key(key).Float(f)
+	return m
+}
+
+func (m *TextKVMapBuilder) Time(key string, t time.Time) *TextKVMapBuilder {
+	m.key(key).Time(t)
+	return m
+}
+
+func (m *TextKVMapBuilder) Duration(key string, d time.Duration) *TextKVMapBuilder {
+	m.key(key).Duration(d)
+	return m
+}
+
+func (m *TextKVMapBuilder) Array(key string, data interface{}) *TextKVMapBuilder {
+	m.key(key).Array(data)
+	return m
+}
+
+func (m *TextKVMapBuilder) Sized(key string, fn func(s *Builder)) *TextKVMapBuilder {
+	m.key(key).Sized(fn)
+	return m
+}
+
+func (m *TextKVMapBuilder) Bool(key string, v bool) *TextKVMapBuilder {
+	m.key(key).Bool(v)
+	return m
+}
+
+func (m *TextKVMapBuilder) Byte(key string, v byte) *TextKVMapBuilder {
+	m.key(key).Byte(v)
+	return m
+}
+
+func (m *TextKVMapBuilder) List(key string, fn func(l *Builder)) *TextKVMapBuilder {
+	m.key(key).List(fn)
+	return m
+}
+
+func (m *TextKVMapBuilder) IdKVMap(key string, fn func(m *IdKVMapBuilder)) *TextKVMapBuilder {
+	m.key(key).IdKVMap(fn)
+	return m
+}
+
+func (m *TextKVMapBuilder) TextKVMap(key string, fn func(m *TextKVMapBuilder)) *TextKVMapBuilder {
+	m.key(key).TextKVMap(fn)
+	return m
+}
+
+func (m *TextKVMapBuilder) BinStream(key string, opts BinstreamOptions, fn func(bsw *BinstreamWriter) error) *TextKVMapBuilder {
+	m.key(key).BinStream(opts, fn)
+	return m
+}
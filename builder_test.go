@@ -0,0 +1,61 @@
+package binproto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	w := new(bytes.Buffer)
+	b := NewBuilder(w)
+
+	b.Request(42).IdKVMap(func(m *IdKVMapBuilder) {
+		m.Bin(16, []byte("hi"))
+		m.BinStream(1, BinstreamOptions{}, func(bsw *BinstreamWriter) error {
+			for _, chunk := range []string{"hello", ", ", "world!"} {
+				if _, err := bsw.Write([]byte(chunk)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		m.List(2, func(l *Builder) { l.Number(1).Number(2) })
+		m.TextKVMap(3, func(m *TextKVMapBuilder) { m.Bin("foo", []byte("bar")) })
+		m.Bool(4, false).Bool(5, true).Byte(6, 10)
+	})
+
+	if err := b.Err(); err != nil {
+		t.Fatalf("Builder failed: %s", err)
+	}
+	if !bytes.Equal(w.Bytes(), data) {
+		t.Errorf("Wrong data constructed, got: %v", w.Bytes())
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if fw.n == 0 {
+		return 0, errors.New("write failed")
+	}
+	fw.n--
+	return len(p), nil
+}
+
+func TestBuilderError(t *testing.T) {
+	b := NewBuilder(&failingWriter{3})
+
+	called := 0
+	b.Event(1).List(func(l *Builder) {
+		called++
+		l.Number(1).Number(2).List(func(l *Builder) { called++ })
+	})
+
+	if err := b.Err(); err == nil || err.Error() != "write failed" {
+		t.Errorf("Expected write error, got: %v", err)
+	}
+	if called != 1 {
+		t.Errorf("Closure called %d times after error", called)
+	}
+}
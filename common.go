@@ -3,6 +3,7 @@ package binproto
 
 import (
 	"errors"
+	"fmt"
 )
 
 type UnitType byte
@@ -23,6 +24,22 @@ const (
 	UTTerm
 	UTBool
 	UTByte
+	UTFloat
+	UTCompactRequest
+	UTCompactAnswer
+	UTCompactEvent
+	UTCompactBin
+	UTCompactNumber
+	UTString
+	UTTime
+	UTDuration
+	UTArray
+	UTUint64
+	UTBigInt
+	UTSized
+	UTStrDef
+	UTStrRef
+	UTStrReset
 )
 
 func (ut UnitType) String() string {
@@ -55,6 +72,44 @@ func (ut UnitType) String() string {
 		return "UTBool"
 	case UTByte:
 		return "UTByte"
+	case UTFloat:
+		return "UTFloat"
+	case UTCompactRequest:
+		return "UTCompactRequest"
+	case UTCompactAnswer:
+		return "UTCompactAnswer"
+	case UTCompactEvent:
+		return "UTCompactEvent"
+	case UTCompactBin:
+		return "UTCompactBin"
+	case UTCompactNumber:
+		return "UTCompactNumber"
+	case UTString:
+		return "UTString"
+	case UTTime:
+		return "UTTime"
+	case UTDuration:
+		return "UTDuration"
+	case UTArray:
+		return "UTArray"
+	case UTUint64:
+		return "UTUint64"
+	case UTBigInt:
+		return "UTBigInt"
+	case UTSized:
+		return "UTSized"
+	case UTStrDef:
+		return "UTStrDef"
+	case UTStrRef:
+		return "UTStrRef"
+	case UTStrReset:
+		return "UTStrReset"
+	}
+	if codec := extCodec(ut); codec != nil {
+		return codec.Name()
+	}
+	if isExt(ut) {
+		return fmt.Sprintf("Extension unit 0x%02x", byte(ut))
 	}
 	return "Unknown unit"
 }
@@ -65,4 +120,7 @@ var (
 	UnexpectedUnit  = errors.New("Unexpected unit received")
 	Terminated      = errors.New("List or KVMap terminated")
 	TooDeeplyNested = errors.New("Received data is too deeply nested to skip")
+	ErrStreamOpen   = errors.New("A BinStream or Array is still open, it must be read or closed first")
+	InvalidUTF8     = errors.New("String is not valid UTF-8")
+	InvalidTime     = errors.New("Invalid Time unit received")
 )
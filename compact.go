@@ -0,0 +1,116 @@
+package binproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/silvasur/kagus"
+	"io"
+	"math"
+)
+
+// Errors of the compact encoding.
+var (
+	InvalidVarint = errors.New("Invalid or too large varint received")
+)
+
+// CompactEncoder is implemented by writers that select the compact encoding for Numbers, Bins and message headers.
+// The Send* functions check for it. Wrappers of other writers (like Message) should delegate it.
+type CompactEncoder interface {
+	CompactEncoding() bool
+}
+
+func useCompact(w io.Writer) bool {
+	ce, ok := w.(CompactEncoder)
+	return ok && ce.CompactEncoding()
+}
+
+// CompactWriter wraps a writer and selects the compact encoding.
+// Only use it, if the peer supports it (e.g. CapCompact was negotiated). Readers always understand both encodings.
+type CompactWriter struct {
+	w io.Writer
+}
+
+// NewCompactWriter creates a CompactWriter that writes to w.
+func NewCompactWriter(w io.Writer) *CompactWriter {
+	return &CompactWriter{w}
+}
+
+// Write implements io.Writer.
+func (cw *CompactWriter) Write(p []byte) (int, error) { return cw.w.Write(p) }
+
+// Flush flushes the underlying writer, see FlushMessage.
+func (cw *CompactWriter) Flush() error { return FlushMessage(cw.w) }
+
+// CompactEncoding implements CompactEncoder.
+func (cw *CompactWriter) CompactEncoding() bool { return true }
+
+// StringTable implements StringTabler, the table of the underlying writer is used.
+func (cw *CompactWriter) StringTable() *StringTable { return stringTable(cw.w) }
+
+// compactToStandard maps the compact unit types to their standard counterparts.
+func compactToStandard(ut UnitType) UnitType {
+	switch ut {
+	case UTCompactRequest:
+		return UTRequest
+	case UTCompactAnswer:
+		return UTAnswer
+	case UTCompactEvent:
+		return UTEvent
+	case UTCompactBin:
+		return UTBin
+	case UTCompactNumber:
+		return UTNumber
+	}
+	return ut
+}
+
+func writeTypedUvarint(w io.Writer, ut UnitType, x uint64) error {
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = byte(ut)
+	n := binary.PutUvarint(buf[1:], x)
+	_, err := w.Write(buf[:1+n])
+	return err
+}
+
+func writeTypedVarint(w io.Writer, ut UnitType, x int64) error {
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = byte(ut)
+	n := binary.PutVarint(buf[1:], x) // PutVarint uses zig-zag encoding
+	_, err := w.Write(buf[:1+n])
+	return err
+}
+
+// readUvarint reads an unsigned varint, that must not be larger than max.
+func readUvarint(r io.Reader, max uint64) (uint64, error) {
+	var x uint64
+	var shift uint
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := kagus.ReadByte(r)
+		if err != nil {
+			return 0, err
+		}
+		if b < 0x80 {
+			if i == binary.MaxVarintLen64-1 && b > 1 {
+				return 0, InvalidVarint
+			}
+			x |= uint64(b) << shift
+			if x > max {
+				return 0, InvalidVarint
+			}
+			return x, nil
+		}
+		x |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+	return 0, InvalidVarint
+}
+
+// readVarint reads a zig-zag encoded signed varint.
+func readVarint(r io.Reader) (int64, error) {
+	ux, err := readUvarint(r, math.MaxUint64)
+	x := int64(ux >> 1)
+	if ux&1 != 0 {
+		x = ^x
+	}
+	return x, err
+}
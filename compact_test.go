@@ -0,0 +1,113 @@
+package binproto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func sendTelemetry(w io.Writer, i int64) error {
+	return NewBuilder(w).
+		Event(7).
+		IdKVMap(func(m *IdKVMapBuilder) {
+			m.Number(1, i).Number(2, i%100).Number(3, -3).Bin(4, []byte("eth0"))
+		}).
+		Err()
+}
+
+func TestCompactEncoding(t *testing.T) {
+	w := new(bytes.Buffer)
+	cw := NewCompactWriter(w)
+	chkerr(t, SendNumber(cw, -1), "SendNumber")
+	chkerr(t, SendNumber(cw, 300), "SendNumber")
+	chkerr(t, InitAnswer(cw, 1000), "InitAnswer")
+	chkerr(t, SendBin(cw, []byte("hi")), "SendBin")
+
+	want := []byte{
+		0x13, 0x01, // CompactNumber(-1)
+		0x13, 0xd8, 0x04, // CompactNumber(300)
+		0x10, 0xe8, 0x07, // CompactAnswer(1000)
+		0x12, 0x02, 'h', 'i'} // CompactBin(hi)
+	if !bytes.Equal(w.Bytes(), want) {
+		t.Fatalf("Wrong data constructed, got: %v", w.Bytes())
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(want))
+	if n := readExpect2(t, ur, UTNumber).(int64); n != -1 {
+		t.Errorf("Wrong number: %d", n)
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 300 {
+		t.Errorf("Wrong number: %d", n)
+	}
+	if code := readExpect2(t, ur, UTAnswer).(uint16); code != 1000 {
+		t.Errorf("Wrong code: %d", code)
+	}
+	if b := readExpect2(t, ur, UTBin).([]byte); string(b) != "hi" {
+		t.Errorf("Wrong bin: %q", b)
+	}
+
+	// Too large for a code
+	ur = NewSimpleUnitReader(bytes.NewReader([]byte{0x0f, 0x80, 0x80, 0x04}))
+	if _, _, err := ur.ReadUnit(); err != InvalidVarint {
+		t.Errorf("Expected InvalidVarint, got: %v", err)
+	}
+}
+
+func TestCompactWrappers(t *testing.T) {
+	// The encoding must be passed through the wrappers, the result must be valid and readable.
+	compact := new(bytes.Buffer)
+	mw := NewMessageWriter(NewCompactWriter(compact))
+	m := mw.Begin()
+	vw := NewValidatingWriter(m)
+	chkerr(t, sendTelemetry(vw, 12), "sendTelemetry")
+	chkerr(t, vw.CheckComplete(), "CheckComplete")
+	chkerr(t, m.Close(), "Close")
+
+	standard := new(bytes.Buffer)
+	chkerr(t, sendTelemetry(standard, 12), "sendTelemetry")
+
+	if compact.Len() >= standard.Len() {
+		t.Fatalf("Compact encoding is not smaller: %d >= %d bytes", compact.Len(), standard.Len())
+	}
+
+	ur := NewSimpleUnitReader(compact)
+	if code := readExpect2(t, ur, UTEvent).(uint16); code != 7 {
+		t.Errorf("Wrong code: %d", code)
+	}
+	readExpect2(t, ur, UTIdKVMap)
+	var n1, n2, n3 int64
+	var b4 []byte
+	err := ScanIdKVMap(ur, map[byte]UKeyGetter{
+		1: {Type: UTNumber, Action: ActionStoreNumber(&n1)},
+		2: {Type: UTNumber, Action: ActionStoreNumber(&n2)},
+		3: {Type: UTNumber, Action: ActionStoreNumber(&n3)},
+		4: {Type: UTBin, Action: ActionStoreBin(&b4)},
+	}, true)
+	if err != nil {
+		t.Fatalf("ScanIdKVMap failed: %s", err)
+	}
+	if n1 != 12 || n2 != 12 || n3 != -3 || string(b4) != "eth0" {
+		t.Errorf("Wrong values: %d, %d, %d, %q", n1, n2, n3, b4)
+	}
+}
+
+func BenchmarkEncoding(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		wrap func(io.Writer) io.Writer
+	}{
+		{"standard", func(w io.Writer) io.Writer { return w }},
+		{"compact", func(w io.Writer) io.Writer { return NewCompactWriter(w) }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			buf := new(bytes.Buffer)
+			w := bm.wrap(buf)
+			for i := 0; i < b.N; i++ {
+				if err := sendTelemetry(w, int64(i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len())/float64(b.N), "bytes/msg")
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package binproto
+
+import (
+	"compress/flate"
+	"io"
+	"sync/atomic"
+)
+
+// Flusher is implemented by writers that buffer data, like CompressedConn.
+type Flusher interface {
+	Flush() error
+}
+
+// FlushMessage flushes w, if it is a Flusher. Call this after a complete message was written, so the peer receives it without delay.
+func FlushMessage(w io.Writer) error {
+	if f, ok := w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// CompressionStats contains the byte counters of a CompressedConn.
+type CompressionStats struct {
+	RawOut, CompressedOut uint64 // Bytes written to the CompressedConn / bytes written to the underlying connection.
+	RawIn, CompressedIn   uint64 // Bytes read from the CompressedConn / bytes read from the underlying connection.
+}
+
+func ratio(compressed, raw uint64) float64 {
+	if raw == 0 {
+		return 1
+	}
+	return float64(compressed) / float64(raw)
+}
+
+// RatioOut returns the compression ratio (compressed / raw) of the sent data.
+func (s CompressionStats) RatioOut() float64 { return ratio(s.CompressedOut, s.RawOut) }
+
+// RatioIn returns the compression ratio (compressed / raw) of the received data.
+func (s CompressionStats) RatioIn() float64 { return ratio(s.CompressedIn, s.RawIn) }
+
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddUint64(cr.n, uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddUint64(cw.n, uint64(n))
+	return n, err
+}
+
+// CompressedConn wraps a connection and compresses all data with deflate.
+// Both sides must use a CompressedConn, usually after they negotiated CapCompression.
+//
+// Written data is buffered, you need to call Flush (or FlushMessage) at the end of every message. The Send* functions
+// and the Builder don't do that on their own, without a flush the peer might never receive the message.
+// Message.Close flushes automatically.
+type CompressedConn struct {
+	stats CompressionStats // Must be the first field, so the counters are 64 bit aligned for the atomic operations on 32 bit platforms.
+	fr    io.ReadCloser
+	fw    *flate.Writer
+}
+
+// NewCompressedConn wraps rw. level is a compress/flate compression level.
+func NewCompressedConn(rw io.ReadWriter, level int) (*CompressedConn, error) {
+	cc := new(CompressedConn)
+
+	fw, err := flate.NewWriter(countingWriter{rw, &cc.stats.CompressedOut}, level)
+	if err != nil {
+		return nil, err
+	}
+
+	cc.fw = fw
+	cc.fr = flate.NewReader(countingReader{rw, &cc.stats.CompressedIn})
+	return cc, nil
+}
+
+// Read implements io.Reader.
+func (cc *CompressedConn) Read(p []byte) (int, error) {
+	n, err := cc.fr.Read(p)
+	atomic.AddUint64(&cc.stats.RawIn, uint64(n))
+	return n, err
+}
+
+// Write implements io.Writer.
+func (cc *CompressedConn) Write(p []byte) (int, error) {
+	n, err := cc.fw.Write(p)
+	atomic.AddUint64(&cc.stats.RawOut, uint64(n))
+	return n, err
+}
+
+// Flush sends all buffered data to the peer.
+func (cc *CompressedConn) Flush() error {
+	return cc.fw.Flush()
+}
+
+// Close terminates the compressed stream. The underlying connection will not be closed.
+func (cc *CompressedConn) Close() error {
+	return cc.fw.Close()
+}
+
+// Stats returns the current byte counters.
+func (cc *CompressedConn) Stats() CompressionStats {
+	return CompressionStats{
+		RawOut:        atomic.LoadUint64(&cc.stats.RawOut),
+		CompressedOut: atomic.LoadUint64(&cc.stats.CompressedOut),
+		RawIn:         atomic.LoadUint64(&cc.stats.RawIn),
+		CompressedIn:  atomic.LoadUint64(&cc.stats.CompressedIn),
+	}
+}
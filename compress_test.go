@@ -0,0 +1,123 @@
+package binproto
+
+import (
+	"bytes"
+	"compress/flate"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connC.Close()
+	defer connS.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		caps, err := ServerHandshake(connS, CapCompression)
+		if err == nil && caps != CapCompression {
+			t.Errorf("Server accepted wrong capabilities: %d", caps)
+		}
+		errs <- err
+	}()
+
+	caps, err := ClientHandshake(connC, CapCompression|Capability(1<<20))
+	if err != nil {
+		t.Fatalf("ClientHandshake failed: %s", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("ServerHandshake failed: %s", err)
+	}
+
+	if !caps.Has(CapCompression) || caps.Has(Capability(1<<20)) {
+		t.Errorf("Wrong capabilities negotiated: %d", caps)
+	}
+}
+
+type bufConn struct {
+	bytes.Buffer
+}
+
+func TestCompressedConn(t *testing.T) {
+	conn := new(bufConn)
+	cc, err := NewCompressedConn(conn, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("Could not create CompressedConn: %s", err)
+	}
+
+	keys := []string{"temperature", "humidity", "pressure", "wind_speed", "wind_direction"}
+
+	for i := 0; i < 100; i++ {
+		chkerr(t, InitEvent(cc, 1), "InitEvent")
+		chkerr(t, InitTextKVMap(cc), "InitTextKVMap")
+		for _, key := range keys {
+			chkerr(t, SendTextKey(cc, key), "SendTextKey")
+			chkerr(t, SendNumber(cc, int64(i)), "SendNumber")
+		}
+		chkerr(t, SendTerm(cc), "SendTerm")
+		chkerr(t, FlushMessage(cc), "FlushMessage")
+	}
+
+	ur := NewSimpleUnitReader(cc)
+	for i := 0; i < 100; i++ {
+		readExpect2(t, ur, UTEvent)
+		readExpect2(t, ur, UTTextKVMap)
+		for _, key := range keys {
+			kvp, err := ReadTextKVPair(ur)
+			if err != nil {
+				t.Fatalf("Could not read TextKVPair: %s", err)
+			}
+			if kvp.Key != key || kvp.ValueType != UTNumber || kvp.ValuePayload.(int64) != int64(i) {
+				t.Fatalf("Wrong textkvp content: %v", kvp)
+			}
+		}
+		readExpect2(t, ur, UTTerm)
+	}
+
+	stats := cc.Stats()
+	if stats.RawOut != stats.RawIn {
+		t.Errorf("Read %d bytes, but wrote %d", stats.RawIn, stats.RawOut)
+	}
+	if r := stats.RatioOut(); r >= 1 {
+		t.Errorf("Bad compression ratio: %f", r)
+	}
+}
+
+func TestCompressedRequestAnswer(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connC.Close()
+	defer connS.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		cc, err := NewCompressedConn(connS, flate.DefaultCompression)
+		if err != nil {
+			errs <- err
+			return
+		}
+		ur := NewSimpleUnitReader(cc)
+		if _, err := ReadExpect(ur, UTRequest); err != nil {
+			errs <- err
+			return
+		}
+		n, err := ReadExpect(ur, UTNumber)
+		if err != nil {
+			errs <- err
+			return
+		}
+		errs <- NewBuilder(cc).Answer(1).Number(n.(int64) * 2).Flush().Err()
+	}()
+
+	cc, err := NewCompressedConn(connC, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("Could not create CompressedConn: %s", err)
+	}
+	chkerr(t, NewBuilder(cc).Request(1).Number(21).Flush().Err(), "Builder")
+
+	ur := NewSimpleUnitReader(cc)
+	readExpect2(t, ur, UTAnswer)
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 42 {
+		t.Errorf("Wrong answer: %d", n)
+	}
+	chkerr(t, <-errs, "Server")
+}
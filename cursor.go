@@ -0,0 +1,120 @@
+package binproto
+
+import (
+	"errors"
+)
+
+// Errors of Cursor.
+var (
+	NoContainer = errors.New("Cursor is not positioned on a container")
+)
+
+func isContainer(ut UnitType) bool {
+	switch ut {
+	case UTList, UTTextKVMap, UTIdKVMap:
+		return true
+	}
+	return false
+}
+
+// Cursor reads units from a UnitReader and keeps track of the nesting of Lists and KVMaps.
+//
+// Containers you are not interested in and the rest of a container you leave early are skipped automatically,
+// so you can't lose the position in the stream.
+type Cursor struct {
+	ur      UnitReader
+	depth   int
+	ended   bool // Term of the current container was already read
+	pending bool // The unit returned by the last Next was not entered / skipped yet
+	ut      UnitType
+	data    interface{}
+}
+
+// NewCursor creates a Cursor at the top level of ur.
+func NewCursor(ur UnitReader) *Cursor {
+	return &Cursor{ur: ur}
+}
+
+// Depth returns the number of containers that were entered and not yet exited.
+func (c *Cursor) Depth() int {
+	return c.depth
+}
+
+func (c *Cursor) skipPending() error {
+	if !c.pending {
+		return nil
+	}
+	c.pending = false
+	return SkipUnit(c.ur, c.ut, c.data)
+}
+
+// Next reads the next unit of the current container. The outputs are the same as from ReadUnit.
+//
+// If the previous unit was a container that was not entered, a BinStream or an Array, it is skipped first.
+// At the end of the current container, Terminated is returned (use Exit to continue with the parent container).
+func (c *Cursor) Next() (UnitType, interface{}, error) {
+	if err := c.skipPending(); err != nil {
+		return 0, nil, err
+	}
+
+	if c.ended {
+		return 0, nil, Terminated
+	}
+
+	ut, data, err := c.ur.ReadUnit()
+	if err != nil {
+		return ut, data, err
+	}
+
+	if ut == UTTerm {
+		if c.depth == 0 {
+			return ut, data, UnexpectedUnit
+		}
+		c.ended = true
+		return 0, nil, Terminated
+	}
+
+	c.ut, c.data, c.pending = ut, data, true
+	return ut, data, nil
+}
+
+// Enter descends into the List, TextKVMap or IdKVMap that was returned by the last call of Next.
+func (c *Cursor) Enter() error {
+	if !c.pending || !isContainer(c.ut) {
+		return NoContainer
+	}
+
+	c.pending = false
+	c.depth++
+	return nil
+}
+
+// Exit skips the rest of the current container and returns to the parent container.
+func (c *Cursor) Exit() error {
+	if c.depth == 0 {
+		return NoContainer
+	}
+
+	if err := c.skipPending(); err != nil {
+		return err
+	}
+
+	for !c.ended {
+		ut, data, err := c.ur.ReadUnit()
+		if err != nil {
+			return err
+		}
+
+		if ut == UTTerm {
+			break
+		}
+
+		if err := SkipUnit(c.ur, ut, data); err != nil {
+			return err
+		}
+	}
+
+	c.ended = false
+	c.depth--
+	return nil
+}
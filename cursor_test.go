@@ -0,0 +1,68 @@
+package binproto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	r := bytes.NewReader(append(append([]byte{}, data...),
+		0x05, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)) // Number(8)
+	c := NewCursor(NewSimpleUnitReader(r))
+
+	if ut, code, err := c.Next(); err != nil || ut != UTRequest || code.(uint16) != 42 {
+		t.Fatalf("Expected Request(42), got: %s, %v, %v", ut, code, err)
+	}
+	if ut, _, err := c.Next(); err != nil || ut != UTIdKVMap {
+		t.Fatalf("Expected IdKVMap, got: %s, %v", ut, err)
+	}
+	if err := c.Enter(); err != nil {
+		t.Fatalf("Could not enter IdKVMap: %s", err)
+	}
+
+	for {
+		ut, key, err := c.Next()
+		if err != nil {
+			t.Fatalf("Could not read key: %s", err)
+		}
+		chkUnitType(t, ut, UTUKey)
+
+		ut, _, err = c.Next()
+		if err != nil {
+			t.Fatalf("Could not read value: %s", err)
+		}
+
+		if key.(byte) == 2 {
+			chkUnitType(t, ut, UTList)
+			break
+		}
+	}
+
+	// Only read the first item of the List at key 2 and leave the IdKVMap early.
+	if err := c.Enter(); err != nil {
+		t.Fatalf("Could not enter List: %s", err)
+	}
+	if ut, n, err := c.Next(); err != nil || ut != UTNumber || n.(int64) != 1 {
+		t.Fatalf("Expected Number(1), got: %s, %v, %v", ut, n, err)
+	}
+	if c.Depth() != 2 {
+		t.Errorf("Wrong depth: %d", c.Depth())
+	}
+	if err := c.Exit(); err != nil {
+		t.Fatalf("Could not exit List: %s", err)
+	}
+	if err := c.Exit(); err != nil {
+		t.Fatalf("Could not exit IdKVMap: %s", err)
+	}
+
+	if ut, n, err := c.Next(); err != nil || ut != UTNumber || n.(int64) != 8 {
+		t.Fatalf("Expected Number(8), got: %s, %v, %v", ut, n, err)
+	}
+	if _, _, err := c.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got: %v", err)
+	}
+	if err := c.Exit(); err != NoContainer {
+		t.Errorf("Expected NoContainer, got: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package binproto
 type urReturn struct {
 	ut   UnitType
 	data interface{}
+	err  error // Error of a unit that was consumed anyway, see unitConsumed
 }
 
 type Demux struct {
@@ -27,7 +28,7 @@ func (d *Demux) demux() {
 
 	for {
 		ut, data, err := d.ur.ReadUnit()
-		if err != nil {
+		if err != nil && !unitConsumed(ut, err) {
 			d.err = err
 			close(d.events)
 			close(d.other)
@@ -42,17 +43,22 @@ func (d *Demux) demux() {
 				nesting--
 			}
 
-			d.events <- urReturn{ut, data}
+			d.events <- urReturn{ut, data, err}
 
 			if nesting <= 0 {
 				inEvent = false
 			}
 		} else if ut == UTEvent {
-			d.events <- urReturn{ut, data}
+			d.events <- urReturn{ut, data, err}
 			inEvent = true
 			nesting = 0
 		} else {
-			d.other <- urReturn{ut, data}
+			d.other <- urReturn{ut, data, err}
+		}
+
+		if lazy, ok := data.(interface{ Done() <-chan struct{} }); ok {
+			// BinStream or Array: We can only continue, when the receiver is done with the payload.
+			<-lazy.Done()
 		}
 	}
 }
@@ -71,5 +77,5 @@ func (pur *PartUnitReader) ReadUnit() (UnitType, interface{}, error) {
 		return 0, nil, pur.d.err
 	}
 
-	return urr.ut, urr.data, nil
+	return urr.ut, urr.data, urr.err
 }
@@ -3,6 +3,7 @@ package binproto
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"testing"
 )
 
@@ -73,3 +74,50 @@ func TestDemux(t *testing.T) {
 		t.Errorf("Expected io.EOF, got: %s", err)
 	}
 }
+
+func TestDemuxBinstream(t *testing.T) {
+	w := new(bytes.Buffer)
+	chkerr(t, InitAnswer(w, 1), "InitAnswer")
+	writeStream(t, w, BinstreamOptions{}, []byte("hello"))
+	chkerr(t, InitEvent(w, 2), "InitEvent")
+	chkerr(t, SendNil(w), "SendNil")
+
+	demux := NewDemux(NewSimpleUnitReader(w))
+	events := demux.Events()
+	other := demux.Other()
+
+	readExpect2(t, other, UTAnswer)
+	bsr := readExpect2(t, other, UTBinStream).(*BinstreamReader)
+	if d, err := ioutil.ReadAll(bsr); err != nil || string(d) != "hello" {
+		t.Fatalf("Could not read stream: %v, %s", d, err)
+	}
+
+	readExpect2(t, events, UTEvent)
+	readExpect2(t, events, UTNil)
+}
+
+func TestDemuxUnknownUnit(t *testing.T) {
+	r := bytes.NewReader([]byte{
+		0x02, 0x01, 0x00, // Answer(1)
+		0xfe, 0x01, 0x00, 0x00, 0x00, 0xaa, // Unregistered extension unit
+		0x03, 0x02, 0x00, // Event(2)
+		0x0d, 0x2a, // Byte(42)
+	})
+
+	demux := NewDemux(NewSimpleUnitReader(r))
+	events := demux.Events()
+	other := demux.Other()
+
+	if _, err := ReadExpect(other, UTAnswer); err != nil {
+		t.Fatalf("Could not read an Answer from other: %s", err)
+	}
+	if ut, _, err := other.ReadUnit(); ut != 0xfe || err != UnknownUnit {
+		t.Errorf("Expected UnknownUnit, got: %s, %v", ut, err)
+	}
+	if _, err := ReadExpect(events, UTEvent); err != nil {
+		t.Fatalf("Could not read an event after the unknown unit: %s", err)
+	}
+	if b, err := ReadExpect(events, UTByte); err != nil || b.(byte) != 42 {
+		t.Errorf("Unexpected event data: %v (err: %v)", b, err)
+	}
+}
@@ -0,0 +1,139 @@
+package binproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Unit type bytes from UTExtFirst to UTExtLast are reserved for extension types, see RegisterUnitType.
+const (
+	UTExtFirst UnitType = 0x80
+	UTExtLast  UnitType = 0xff
+)
+
+// Errors of extension types.
+var (
+	NotAnExtension    = errors.New("Unit type is not in the range of extension types")
+	AlreadyRegistered = errors.New("Extension unit type is already registered")
+	ExtTooLarge       = errors.New("Encoded extension unit is too large")
+)
+
+// ExtDecodeError is returned by ReadUnit, if the codec of an extension type could not decode the payload.
+// The unit was read completely, so reading can continue.
+type ExtDecodeError struct {
+	Unit UnitType
+	Err  error
+}
+
+func (e *ExtDecodeError) Error() string {
+	return fmt.Sprintf("Could not decode %s (%d): %s", e.Unit, byte(e.Unit), e.Err)
+}
+
+func (e *ExtDecodeError) Unwrap() error { return e.Err }
+
+// UnitCodec converts values of an extension type from and to their binary payload.
+type UnitCodec interface {
+	Name() string                               // Name of the type, used e.g. by UnitType.String
+	Encode(v interface{}) ([]byte, error)       // Encode the value v, used by SendExt
+	Decode(payload []byte) (interface{}, error) // Decode a payload, ReadUnit returns the result
+	Format(v interface{}) string                // Human readable representation of a decoded value
+}
+
+var (
+	extMu     sync.RWMutex
+	extCodecs [int(UTExtLast-UTExtFirst) + 1]UnitCodec
+)
+
+func isExt(ut UnitType) bool {
+	return ut >= UTExtFirst
+}
+
+func extCodec(ut UnitType) UnitCodec {
+	if !isExt(ut) {
+		return nil
+	}
+
+	extMu.RLock()
+	defer extMu.RUnlock()
+	return extCodecs[ut-UTExtFirst]
+}
+
+// RegisterUnitType registers a codec for an extension unit type. t must be between UTExtFirst and UTExtLast.
+// The registration is global, usually it is done in an init function.
+//
+// Extension units consist of the type byte, a 4 byte length and the payload produced by the codec.
+// Since the length is known, units of unregistered extension types can still be skipped.
+func RegisterUnitType(t UnitType, codec UnitCodec) error {
+	if !isExt(t) {
+		return NotAnExtension
+	}
+
+	extMu.Lock()
+	defer extMu.Unlock()
+
+	if extCodecs[t-UTExtFirst] != nil {
+		return AlreadyRegistered
+	}
+	extCodecs[t-UTExtFirst] = codec
+	return nil
+}
+
+// SendExt encodes v with the codec registered for t and sends it.
+func SendExt(w io.Writer, t UnitType, v interface{}) error {
+	codec := extCodec(t)
+	if codec == nil {
+		return UnknownUnit
+	}
+
+	payload, err := codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	if uint64(len(payload)) > math.MaxUint32 {
+		return ExtTooLarge
+	}
+
+	buf := make([]byte, 5, 5+len(payload))
+	buf[0] = byte(t)
+	binary.LittleEndian.PutUint32(buf[1:], uint32(len(payload)))
+	_, err = w.Write(append(buf, payload...))
+	return err
+}
+
+// readExt reads an extension unit. For unregistered types, the raw payload and UnknownUnit are returned.
+// Errors of the codec are returned as *ExtDecodeError. In all these cases the unit is read completely, so the stream stays usable.
+func readExt(r io.Reader, ut UnitType) (interface{}, error) {
+	var l uint32
+	if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	codec := extCodec(ut)
+	if codec == nil {
+		return payload, UnknownUnit
+	}
+	v, err := codec.Decode(payload)
+	if err != nil {
+		return nil, &ExtDecodeError{ut, err}
+	}
+	return v, nil
+}
+
+// FormatExt returns a human readable representation of the payload of an extension unit, using the Format method of its codec.
+func FormatExt(ut UnitType, data interface{}) string {
+	if codec := extCodec(ut); codec != nil {
+		return codec.Format(data)
+	}
+	if payload, ok := data.([]byte); ok {
+		return fmt.Sprintf("%x", payload)
+	}
+	return fmt.Sprint(data)
+}
@@ -0,0 +1,130 @@
+package binproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type point struct{ X, Y int32 }
+
+type pointCodec struct{}
+
+func (pointCodec) Name() string { return "Point" }
+
+func (pointCodec) Encode(v interface{}) ([]byte, error) {
+	p := v.(point)
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, uint32(p.X))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(p.Y))
+	return buf, nil
+}
+
+func (pointCodec) Decode(payload []byte) (interface{}, error) {
+	if len(payload) != 8 {
+		return nil, errors.New("invalid point")
+	}
+	return point{int32(binary.LittleEndian.Uint32(payload)), int32(binary.LittleEndian.Uint32(payload[4:]))}, nil
+}
+
+func (pointCodec) Format(v interface{}) string {
+	p := v.(point)
+	return fmt.Sprintf("(%d, %d)", p.X, p.Y)
+}
+
+const utPoint = UTExtFirst + 1
+
+var registerPoint sync.Once
+
+func TestExtensionUnits(t *testing.T) {
+	registerPoint.Do(func() {
+		chkerr(t, RegisterUnitType(utPoint, pointCodec{}), "RegisterUnitType")
+	})
+	if err := RegisterUnitType(utPoint, pointCodec{}); err != AlreadyRegistered {
+		t.Errorf("Expected AlreadyRegistered, got: %v", err)
+	}
+	if err := RegisterUnitType(UTBin, pointCodec{}); err != NotAnExtension {
+		t.Errorf("Expected NotAnExtension, got: %v", err)
+	}
+	if s := utPoint.String(); s != "Point" {
+		t.Errorf("Wrong name: %s", s)
+	}
+
+	w := new(bytes.Buffer)
+	vw := NewValidatingWriter(w)
+	chkerr(t, InitList(vw), "InitList")
+	chkerr(t, SendExt(vw, utPoint, point{1, -2}), "SendExt")
+	chkerr(t, SendTerm(vw), "SendTerm")
+	chkerr(t, vw.CheckComplete(), "CheckComplete")
+	if err := SendExt(w, UTExtLast, point{}); err != UnknownUnit {
+		t.Errorf("Expected UnknownUnit, got: %v", err)
+	}
+
+	// An unregistered extension unit
+	w.Write([]byte{byte(UTExtLast), 0x02, 0x00, 0x00, 0x00, 0xab, 0xcd})
+	chkerr(t, SendNumber(w, 42), "SendNumber")
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	readExpect2(t, ur, UTList)
+	p := readExpect2(t, ur, utPoint)
+	if p != (point{1, -2}) {
+		t.Errorf("Wrong point: %v", p)
+	}
+	if s := FormatExt(utPoint, p); s != "(1, -2)" {
+		t.Errorf("Wrong format: %s", s)
+	}
+	readExpect2(t, ur, UTTerm)
+
+	ut, data, err := ur.ReadUnit()
+	if ut != UTExtLast || err != UnknownUnit || !bytes.Equal(data.([]byte), []byte{0xab, 0xcd}) {
+		t.Errorf("Unexpected result for unregistered unit: %s, %v, %v", ut, data, err)
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 42 {
+		t.Errorf("Wrong number after extension unit: %d", n)
+	}
+
+	ur = NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if err := SkipNext(ur); err != nil {
+		t.Fatalf("Skipping failed: %s", err)
+	}
+}
+
+func TestSkipUnknownExtension(t *testing.T) {
+	registerPoint.Do(func() {
+		chkerr(t, RegisterUnitType(utPoint, pointCodec{}), "RegisterUnitType")
+	})
+
+	w := new(bytes.Buffer)
+	chkerr(t, InitList(w), "InitList")
+	w.Write([]byte{0xfe, 0x01, 0x00, 0x00, 0x00, 0xaa})          // Unregistered
+	w.Write([]byte{byte(utPoint), 0x01, 0x00, 0x00, 0x00, 0xaa}) // Registered, but invalid
+	chkerr(t, InitIdKVMap(w), "InitIdKVMap")
+	chkerr(t, SendUKey(w, 1), "SendUKey")
+	w.Write([]byte{0xfe, 0x00, 0x00, 0x00, 0x00})
+	chkerr(t, SendTerm(w), "SendTerm")
+	chkerr(t, SendTerm(w), "SendTerm")
+	chkerr(t, SendNumber(w, 5), "SendNumber")
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if err := SkipNext(ur); err != nil {
+		t.Fatalf("Skipping failed: %s", err)
+	}
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 5 {
+		t.Errorf("Wrong number after skipped List: %d", n)
+	}
+
+	ur = NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	readExpect2(t, ur, UTList)
+	if ut, _, err := ur.ReadUnit(); ut != 0xfe || err != UnknownUnit {
+		t.Errorf("Expected UnknownUnit for 0xfe, got: %s, %v", ut, err)
+	}
+	if ut, _, err := ur.ReadUnit(); ut != utPoint {
+		t.Errorf("Expected a Point, got: %s", ut)
+	} else if _, ok := err.(*ExtDecodeError); !ok {
+		t.Errorf("Expected an *ExtDecodeError, got: %v", err)
+	}
+	readExpect2(t, ur, UTIdKVMap)
+}
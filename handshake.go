@@ -0,0 +1,71 @@
+package binproto
+
+import (
+	"errors"
+	"io"
+)
+
+// Capability is a bit set of optional protocol features that can be negotiated with ClientHandshake and ServerHandshake.
+type Capability uint32
+
+// Possible Capability bits
+const (
+	CapCompression Capability = 1 << iota // Wrap the connection with NewCompressedConn after the handshake.
+	CapCompact                            // Both sides understand the compact encoding, wrap the writer with NewCompactWriter after the handshake.
+)
+
+// Has tests, if all bits of want are set.
+func (c Capability) Has(want Capability) bool {
+	return c&want == want
+}
+
+// Errors of the handshake.
+var (
+	HandshakeFailed = errors.New("Handshake failed")
+)
+
+// ClientHandshake offers the capabilities in offer to the server and returns the capabilities both sides agreed on.
+//
+// The handshake must be the very first thing sent over a connection. It consists of a Number unit with the
+// offered capabilities (client to server) and a Number unit with the accepted capabilities (server to client).
+func ClientHandshake(rw io.ReadWriter, offer Capability) (Capability, error) {
+	if err := SendNumber(rw, int64(offer)); err != nil {
+		return 0, err
+	}
+
+	return readCapabilities(rw)
+}
+
+// ServerHandshake reads the offer of a client, accepts all capabilities that are also in supported and tells the client about that.
+// The accepted capabilities are returned.
+func ServerHandshake(rw io.ReadWriter, supported Capability) (Capability, error) {
+	offer, err := readCapabilities(rw)
+	if err != nil {
+		return 0, err
+	}
+
+	accepted := offer & supported
+	if err := SendNumber(rw, int64(accepted)); err != nil {
+		return 0, err
+	}
+	return accepted, nil
+}
+
+func readCapabilities(r io.Reader) (Capability, error) {
+	// A SimpleUnitReader does not read ahead, so it is safe to throw it away after the handshake.
+	ur := NewSimpleUnitReader(r)
+	data, err := ReadExpect(ur, UTNumber)
+	switch err {
+	case nil:
+	case UnexpectedUnit:
+		return 0, HandshakeFailed
+	default:
+		return 0, err
+	}
+
+	n := data.(int64)
+	if n < 0 || n > int64(^Capability(0)) {
+		return 0, HandshakeFailed
+	}
+	return Capability(n), nil
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"time"
 )
 
 // UKeyGetter defines what to do on a UKey. Used by ScanIdKVMap.
@@ -146,6 +148,30 @@ func ActionStoreNumber(n *int64) GetterAction {
 	}
 }
 
+// ActionStoreUint64 builds an action for storing an unsigned number.
+func ActionStoreUint64(n *uint64) GetterAction {
+	return func(data interface{}, ur UnitReader) (error, bool) {
+		*n = data.(uint64)
+		return nil, false
+	}
+}
+
+// ActionStoreBigInt builds an action for storing a big integer.
+func ActionStoreBigInt(n **big.Int) GetterAction {
+	return func(data interface{}, ur UnitReader) (error, bool) {
+		*n = data.(*big.Int)
+		return nil, false
+	}
+}
+
+// ActionStoreFloat builds an action for storing a float.
+func ActionStoreFloat(f *float64) GetterAction {
+	return func(data interface{}, ur UnitReader) (error, bool) {
+		*f = data.(float64)
+		return nil, false
+	}
+}
+
 // ActionStoreBin builds an action for storing binary data.
 func ActionStoreBin(b *[]byte) GetterAction {
 	return func(data interface{}, ur UnitReader) (error, bool) {
@@ -154,6 +180,30 @@ func ActionStoreBin(b *[]byte) GetterAction {
 	}
 }
 
+// ActionStoreString builds an action for storing a string.
+func ActionStoreString(s *string) GetterAction {
+	return func(data interface{}, ur UnitReader) (error, bool) {
+		*s = data.(string)
+		return nil, false
+	}
+}
+
+// ActionStoreTime builds an action for storing a time.
+func ActionStoreTime(t *time.Time) GetterAction {
+	return func(data interface{}, ur UnitReader) (error, bool) {
+		*t = data.(time.Time)
+		return nil, false
+	}
+}
+
+// ActionStoreDuration builds an action for storing a duration.
+func ActionStoreDuration(d *time.Duration) GetterAction {
+	return func(data interface{}, ur UnitReader) (error, bool) {
+		*d = data.(time.Duration)
+		return nil, false
+	}
+}
+
 // ActionStoreBool builds an action for storing a boolean value.
 func ActionStoreBool(b *bool) GetterAction {
 	return func(data interface{}, ur UnitReader) (error, bool) {
@@ -185,3 +235,54 @@ func ActionCopyBinStream(w io.Writer) GetterAction {
 		return nil, false
 	}
 }
+
+// ActionStoreArray builds an action for storing all elements of an Array. dst must be a *[]int64, *[]int32, *[]uint8 or *[]float64.
+// If the array has a different kind, WrongArrayKind is returned. Other types of dst (including nil pointers) result in
+// InvalidArrayTarget. In both cases, the array is skipped.
+func ActionStoreArray(dst interface{}) GetterAction {
+	return func(data interface{}, ur UnitReader) (error, bool) {
+		ar := data.(*ArrayReader)
+
+		kind, ok := arrayTargetKind(dst)
+		if !ok || kind != ar.Kind() {
+			if err := ar.Close(); err != nil {
+				return err, true
+			}
+			if !ok {
+				return InvalidArrayTarget, false
+			}
+			return WrongArrayKind, false
+		}
+
+		s, err := ar.ReadAll()
+		if err != nil {
+			return err, true
+		}
+		switch d := dst.(type) {
+		case *[]int64:
+			*d = s.([]int64)
+		case *[]int32:
+			*d = s.([]int32)
+		case *[]uint8:
+			*d = s.([]uint8)
+		case *[]float64:
+			*d = s.([]float64)
+		}
+		return nil, false
+	}
+}
+
+// arrayTargetKind returns the ArrayKind that can be stored in dst (see ActionStoreArray).
+func arrayTargetKind(dst interface{}) (ArrayKind, bool) {
+	switch d := dst.(type) {
+	case *[]int64:
+		return ArrayInt64, d != nil
+	case *[]int32:
+		return ArrayInt32, d != nil
+	case *[]uint8:
+		return ArrayUint8, d != nil
+	case *[]float64:
+		return ArrayFloat64, d != nil
+	}
+	return 0, false
+}
@@ -0,0 +1,98 @@
+package binproto
+
+import (
+	"iter"
+)
+
+// Item will be yielded by ListItems. Type and Payload are the first two outputs of ReadUnit.
+type Item struct {
+	Type    UnitType
+	Payload interface{}
+}
+
+// skipRest is used when leaving an iteration early. The unread part of a BinStream or Array of the current item is skipped,
+// then the rest of the container. A nested List or KVMap must have been read or skipped by the loop body, it is not touched.
+// Errors are lost, but the next ReadUnit will most likely fail in that case, too.
+func skipRest(ur UnitReader, container UnitType, ut UnitType, data interface{}) {
+	switch ut {
+	case UTBinStream:
+		if err := data.(*BinstreamReader).FastForward(); err != nil {
+			return
+		}
+	case UTArray:
+		if err := data.(*ArrayReader).Close(); err != nil {
+			return
+		}
+	}
+	SkipUnit(ur, container, nil)
+}
+
+// ListItems iterates over the items of a List. The input stream must be positioned after the opening UTList.
+//
+// Like with ReadUnit, the loop body must read or skip a nested List or KVMap completely (e.g. with SkipUnit), also before
+// leaving the loop. If the loop is left early, the rest of the List is skipped (including the unread part of a BinStream or
+// Array of the current item). An error ends the iteration.
+func ListItems(ur UnitReader) iter.Seq2[Item, error] {
+	return func(yield func(Item, error) bool) {
+		for {
+			ut, data, err := ur.ReadUnit()
+			if err != nil {
+				yield(Item{}, err)
+				return
+			}
+
+			if ut == UTTerm {
+				return
+			}
+
+			if !yield(Item{ut, data}, nil) {
+				skipRest(ur, UTList, ut, data)
+				return
+			}
+		}
+	}
+}
+
+// IdKVPairs iterates over the pairs of an IdKVMap, using ReadIdKVPair. See ListItems for the rules.
+func IdKVPairs(ur UnitReader) iter.Seq2[IdKVPair, error] {
+	return func(yield func(IdKVPair, error) bool) {
+		for {
+			kvp, err := ReadIdKVPair(ur)
+			switch err {
+			case nil:
+			case Terminated:
+				return
+			default:
+				yield(IdKVPair{}, err)
+				return
+			}
+
+			if !yield(kvp, nil) {
+				skipRest(ur, UTIdKVMap, kvp.ValueType, kvp.ValuePayload)
+				return
+			}
+		}
+	}
+}
+
+// TextKVPairs iterates over the pairs of a TextKVMap, using ReadTextKVPair. See ListItems for the rules.
+func TextKVPairs(ur UnitReader) iter.Seq2[TextKVPair, error] {
+	return func(yield func(TextKVPair, error) bool) {
+		for {
+			kvp, err := ReadTextKVPair(ur)
+			switch err {
+			case nil:
+			case Terminated:
+				return
+			default:
+				yield(TextKVPair{}, err)
+				return
+			}
+
+			if !yield(kvp, nil) {
+				skipRest(ur, UTTextKVMap, kvp.ValueType, kvp.ValuePayload)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package binproto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIterators(t *testing.T) {
+	w := new(bytes.Buffer)
+	chkerr(t, InitList(w), "InitList")
+	for i := int64(1); i <= 5; i++ {
+		chkerr(t, SendNumber(w, i), "SendNumber")
+	}
+	chkerr(t, SendTerm(w), "SendTerm")
+	chkerr(t, InitIdKVMap(w), "InitIdKVMap")
+	chkerr(t, SendUKey(w, 1), "SendUKey")
+	chkerr(t, SendBool(w, true), "SendBool")
+	chkerr(t, SendUKey(w, 2), "SendUKey")
+	writeStream(t, w, BinstreamOptions{}, []byte("unread"))
+	chkerr(t, SendUKey(w, 3), "SendUKey")
+	chkerr(t, SendNil(w), "SendNil")
+	chkerr(t, SendTerm(w), "SendTerm")
+	chkerr(t, InitTextKVMap(w), "InitTextKVMap")
+	chkerr(t, SendTextKey(w, "foo"), "SendTextKey")
+	chkerr(t, SendByte(w, 1), "SendByte")
+	chkerr(t, SendTextKey(w, "bar"), "SendTextKey")
+	chkerr(t, SendByte(w, 2), "SendByte")
+	chkerr(t, SendTerm(w), "SendTerm")
+	chkerr(t, SendNumber(w, 8), "SendNumber")
+
+	ur := NewSimpleUnitReader(w)
+
+	readExpect2(t, ur, UTList)
+	var sum int64
+	for item, err := range ListItems(ur) {
+		if err != nil {
+			t.Fatalf("Could not read item: %s", err)
+		}
+		n := item.Payload.(int64)
+		if n > 3 {
+			break
+		}
+		sum += n
+	}
+	if sum != 6 {
+		t.Errorf("Wrong sum: %d", sum)
+	}
+
+	readExpect2(t, ur, UTIdKVMap)
+	for kvp, err := range IdKVPairs(ur) {
+		if err != nil {
+			t.Fatalf("Could not read IdKVPair: %s", err)
+		}
+		if kvp.ValueType == UTBinStream {
+			break // Leave the BinStream unread
+		}
+	}
+
+	readExpect2(t, ur, UTTextKVMap)
+	keys := ""
+	for kvp, err := range TextKVPairs(ur) {
+		if err != nil {
+			t.Fatalf("Could not read TextKVPair: %s", err)
+		}
+		keys += kvp.Key
+	}
+	if keys != "foobar" {
+		t.Errorf("Wrong keys: %s", keys)
+	}
+
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 8 {
+		t.Errorf("Wrong number after maps: %d", n)
+	}
+}
+
+// breakOnNested iterates over a List, skips the first nested container and leaves the loop.
+func breakOnNested(t *testing.T, ur UnitReader) {
+	readExpect2(t, ur, UTList)
+	for item, err := range ListItems(ur) {
+		if err != nil {
+			t.Fatalf("Could not read item: %s", err)
+		}
+		if isContainer(item.Type) {
+			chkerr(t, SkipUnit(ur, item.Type, item.Payload), "SkipUnit")
+			break
+		}
+	}
+}
+
+func TestIteratorNestedBreak(t *testing.T) {
+	w := new(bytes.Buffer)
+	b := NewBuilder(w)
+	for i := 0; i < 2; i++ {
+		b.Answer(uint16(i)).List(func(l *Builder) {
+			l.Number(1).IdKVMap(func(m *IdKVMapBuilder) {
+				m.Number(1, 2).List(2, func(l *Builder) { l.Number(3) })
+			}).Number(99)
+		})
+	}
+	b.Answer(2).Nil()
+	chkerr(t, b.Err(), "Builder")
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	for i := 0; i < 2; i++ {
+		readExpect2(t, ur, UTAnswer)
+		breakOnNested(t, ur)
+	}
+	if code := readExpect2(t, ur, UTAnswer).(uint16); code != 2 {
+		t.Errorf("Wrong answer after lists: %d", code)
+	}
+
+	// Through a UnitReader that is not a SimpleUnitReader
+	other := NewDemux(NewSimpleUnitReader(bytes.NewReader(w.Bytes()))).Other()
+	for i := 0; i < 2; i++ {
+		readExpect2(t, other, UTAnswer)
+		breakOnNested(t, other)
+	}
+	if code := readExpect2(t, other, UTAnswer).(uint16); code != 2 {
+		t.Errorf("Wrong answer after lists read through Demux: %d", code)
+	}
+	readExpect2(t, other, UTNil)
+}
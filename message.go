@@ -0,0 +1,113 @@
+package binproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Errors of Message.
+var (
+	MessageClosed = errors.New("Message was already closed")
+)
+
+// MessageWriter allows multiple goroutines to send messages over the same connection, without mixing up their units.
+// Every goroutine composes its message in a Message, which is sent atomically when it is closed.
+type MessageWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewMessageWriter creates a MessageWriter that sends to w.
+func NewMessageWriter(w io.Writer) *MessageWriter {
+	return &MessageWriter{w: w}
+}
+
+// Begin starts a new message.
+func (mw *MessageWriter) Begin() *Message {
+	return &Message{mw: mw}
+}
+
+// Message collects the units of a message. It is an io.Writer, so the Send* functions and Builder can write to it.
+// A Message must only be used by one goroutine.
+type Message struct {
+	mw        *MessageWriter
+	buf       bytes.Buffer
+	exclusive bool // We hold the lock of mw, writes go directly to the connection
+	closed    bool
+}
+
+// Write implements io.Writer.
+func (m *Message) Write(p []byte) (int, error) {
+	switch {
+	case m.closed:
+		return 0, MessageClosed
+	case m.exclusive:
+		return m.mw.w.Write(p)
+	}
+	return m.buf.Write(p)
+}
+
+// CompactEncoding implements CompactEncoder, the encoding of the underlying writer is used.
+func (m *Message) CompactEncoding() bool {
+	return useCompact(m.mw.w)
+}
+
+// Exclusive sends the data collected so far and keeps the connection locked for this message until Close.
+// All following writes go directly to the connection. Use this for messages with large contents that should not be buffered.
+func (m *Message) Exclusive() error {
+	switch {
+	case m.closed:
+		return MessageClosed
+	case m.exclusive:
+		return nil
+	}
+
+	m.mw.mu.Lock()
+	m.exclusive = true
+	_, err := m.mw.w.Write(m.buf.Bytes())
+	m.buf.Reset()
+	return err
+}
+
+// InitBinStream makes the message exclusive (see Exclusive) and starts a BinStream.
+func (m *Message) InitBinStream(opts BinstreamOptions) (*BinstreamWriter, error) {
+	if err := m.Exclusive(); err != nil {
+		return nil, err
+	}
+	return InitBinStreamWithOptions(m, opts)
+}
+
+// Close sends the message and flushes the connection (see FlushMessage). For exclusive messages, the connection is unlocked.
+func (m *Message) Close() error {
+	if m.closed {
+		return MessageClosed
+	}
+	m.closed = true
+
+	if !m.exclusive {
+		m.mw.mu.Lock()
+	}
+	defer m.mw.mu.Unlock()
+
+	if _, err := m.mw.w.Write(m.buf.Bytes()); err != nil {
+		return err
+	}
+	m.buf.Reset()
+	return FlushMessage(m.mw.w)
+}
+
+// Discard drops the message without sending it.
+// If the message is exclusive, parts of it might already have been sent. The connection is probably unusable in that case.
+func (m *Message) Discard() {
+	if m.closed {
+		return
+	}
+	m.closed = true
+	m.buf.Reset()
+
+	if m.exclusive {
+		m.mw.mu.Unlock()
+	}
+}
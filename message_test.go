@@ -0,0 +1,109 @@
+package binproto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// writeTestMessage writes message i of goroutine g for TestMessageWriter.
+func writeTestMessage(m *Message, g, i int) error {
+	if err := InitEvent(m, uint16(g)); err != nil {
+		return err
+	}
+	if err := InitList(m); err != nil {
+		return err
+	}
+	for j := 0; j < 10; j++ {
+		if err := SendNumber(m, int64(g)); err != nil {
+			return err
+		}
+	}
+
+	if i%10 == 0 {
+		bsw, err := m.InitBinStream(BinstreamOptions{})
+		if err != nil {
+			return err
+		}
+		for j := 0; j < 10; j++ {
+			if _, err := bsw.Write([]byte{byte(g)}); err != nil {
+				return err
+			}
+		}
+		if err := bsw.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := SendTerm(m); err != nil {
+		return err
+	}
+	return m.Close()
+}
+
+func TestMessageWriter(t *testing.T) {
+	w := new(bytes.Buffer)
+	mw := NewMessageWriter(w)
+
+	const goroutines = 8
+	const messages = 50
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				if err := writeTestMessage(mw.Begin(), g, i); err != nil {
+					t.Errorf("Could not write message %d of goroutine %d: %s", i, g, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	ur := NewSimpleUnitReader(w)
+	for i := 0; i < goroutines*messages; i++ {
+		g := readExpect2(t, ur, UTEvent).(uint16)
+		readExpect2(t, ur, UTList)
+		for {
+			ut, data, err := ur.ReadUnit()
+			if err != nil {
+				t.Fatalf("Could not read unit: %s", err)
+			}
+			switch ut {
+			case UTNumber:
+				if data.(int64) != int64(g) {
+					t.Fatalf("Messages were mixed up, got %d in message of %d", data.(int64), g)
+				}
+				continue
+			case UTBinStream:
+				d, err := ioutil.ReadAll(data.(*BinstreamReader))
+				if err != nil {
+					t.Fatalf("Could not read stream: %s", err)
+				}
+				if !bytes.Equal(d, bytes.Repeat([]byte{byte(g)}, 10)) {
+					t.Fatalf("Messages were mixed up, got stream %v in message of %d", d, g)
+				}
+				continue
+			}
+			chkUnitType(t, ut, UTTerm)
+			break
+		}
+	}
+
+	m := mw.Begin()
+	chkerr(t, SendNil(m), "SendNil")
+	m.Discard()
+	if _, err := m.Write([]byte{UTNil}); err != MessageClosed {
+		t.Errorf("Expected MessageClosed, got: %v", err)
+	}
+	if w.Len() != 0 {
+		t.Errorf("Discarded message was sent")
+	}
+}
@@ -0,0 +1,448 @@
+package binproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Frame types of the multiplexing layer. Every frame starts with the type byte and a uint32 channel ID.
+const (
+	frameData   = iota // uint32 length + data
+	frameClose         // The sender will not send more data on this channel.
+	frameWindow        // uint32 increment. The receiver consumed data, the sender may send that many more bytes.
+	frameReset         // The receiver is not interested in the channel any more, the sender should stop sending.
+)
+
+const (
+	muxMainChannel = 0
+	muxWindow      = 256 * 1024 // Flow control window of every channel.
+	muxMaxFrameLen = 32 * 1024  // Larger writes are split, so other channels are not blocked too long.
+	muxMaxPending  = 64         // Maximum number of streams of the peer that were not accepted yet.
+)
+
+// Errors of Mux.
+var (
+	InvalidFrame   = errors.New("Invalid multiplexer frame received")
+	InvalidChannel = errors.New("Invalid multiplexer channel ID")
+	StreamReset    = errors.New("Multiplexed stream was closed by the receiver")
+	StreamAccepted = errors.New("Multiplexed stream was already accepted")
+	TooManyStreams = errors.New("Multiplexed stream was rejected, too many streams were waiting to be accepted")
+)
+
+// Mux multiplexes multiple streams over one connection.
+//
+// Channel 0 (returned by Main) carries the ordinary binproto messages, the other channels are unidirectional streams,
+// opened by OpenStream. A stream is identified by its ID. Usually you send the ID in a message over the main channel,
+// the receiving side can then call AcceptStream with that ID.
+//
+// Every channel has its own flow control window, so a slow reader of one stream will not block the other streams.
+// The peer may only open a limited number of streams that were not accepted yet. Further streams are reset (the sender gets
+// StreamReset), AcceptStream returns TooManyStreams for them.
+type Mux struct {
+	conn    io.ReadWriter
+	wmu     sync.Mutex // Serializes writing frames
+	mu      sync.Mutex // Protects the fields below and the fields of the muxChans
+	cond    *sync.Cond
+	chans   map[uint32]*muxChan
+	nextID  uint32
+	pending int // Channels of the peer that were not accepted yet
+	err     error
+
+	// Finished channels of the peer. All IDs below finishedBelow are finished, the ones above are in finished.
+	finishedBelow uint32
+	finished      map[uint32]bool
+}
+
+// muxChan is the state of a channel. Channels we opened only use the sending half, channels of the peer only the receiving half.
+type muxChan struct {
+	m  *Mux
+	id uint32
+
+	// Receiving half
+	accepted bool // AcceptStream was called
+	rejected bool // Reset, because too many streams were pending
+	buf      bytes.Buffer
+	rclosed  bool   // Peer will not send any more data
+	rdone    bool   // Local reader is gone, data is discarded
+	consumed uint32 // Consumed, but not yet acknowledged bytes
+
+	// Sending half
+	credit  int  // How many bytes we may send
+	reset   bool // Peer is not interested any more
+	wclosed bool // Close was called
+}
+
+// NewMux creates a multiplexer on top of conn and starts reading from conn.
+// One side of the connection must be the client, the other one must not be the client (they use different ID ranges).
+//
+// The Mux stops, when reading from conn fails (e.g. because it was closed). All streams will then return that error.
+func NewMux(conn io.ReadWriter, client bool) *Mux {
+	m := &Mux{
+		conn:          conn,
+		chans:         make(map[uint32]*muxChan),
+		nextID:        2,
+		finishedBelow: 1,
+		finished:      make(map[uint32]bool)}
+	if client {
+		m.nextID = 1
+		m.finishedBelow = 2
+	}
+	m.cond = sync.NewCond(&m.mu)
+	m.chans[muxMainChannel] = m.newChan(muxMainChannel)
+
+	go m.readLoop()
+	return m
+}
+
+func (m *Mux) newChan(id uint32) *muxChan {
+	return &muxChan{m: m, id: id, credit: muxWindow}
+}
+
+// ours tests, if a channel ID was allocated by this side.
+func (m *Mux) ours(id uint32) bool {
+	return id != muxMainChannel && id%2 == m.nextID%2
+}
+
+// finish removes a channel of the peer that was read completely, its ID must not be used again.
+func (m *Mux) finish(id uint32) {
+	delete(m.chans, id)
+	if m.isFinished(id) {
+		return
+	}
+	m.finished[id] = true
+	for m.finished[m.finishedBelow] {
+		delete(m.finished, m.finishedBelow)
+		m.finishedBelow += 2
+	}
+}
+
+func (m *Mux) isFinished(id uint32) bool {
+	return id < m.finishedBelow || m.finished[id]
+}
+
+// Main returns the main channel, use it for the ordinary messages.
+func (m *Mux) Main() io.ReadWriter {
+	return mainChannel{m.chans[muxMainChannel]}
+}
+
+type mainChannel struct{ ch *muxChan }
+
+func (mc mainChannel) Read(p []byte) (int, error)  { return mc.ch.read(p) }
+func (mc mainChannel) Write(p []byte) (int, error) { return mc.ch.write(p) }
+
+// OpenStream opens a new stream for sending data.
+func (m *Mux) OpenStream() (*MuxStreamWriter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	ch := m.newChan(m.nextID)
+	m.nextID += 2
+	m.chans[ch.id] = ch
+	return &MuxStreamWriter{ch}, nil
+}
+
+// AcceptStream returns the stream with the given ID, that was opened by the peer.
+// Data that was received before AcceptStream was called, is buffered.
+// Every stream can only be accepted once, StreamAccepted is returned otherwise.
+func (m *Mux) AcceptStream(id uint32) (*MuxStreamReader, error) {
+	if id == muxMainChannel || m.ours(id) {
+		return nil, InvalidChannel
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch, ok := m.chans[id]
+	switch {
+	case ok && ch.rejected:
+		return nil, TooManyStreams
+	case ok && ch.accepted, !ok && m.isFinished(id):
+		return nil, StreamAccepted
+	case ok:
+		m.pending--
+	case m.err != nil:
+		return nil, m.err
+	default:
+		ch = m.newChan(id)
+		m.chans[id] = ch
+	}
+	ch.accepted = true
+	return &MuxStreamReader{ch}, nil
+}
+
+func (m *Mux) writeFrame(typ byte, id uint32, arg uint32, data []byte) error {
+	buf := make([]byte, 5, 9+len(data))
+	buf[0] = typ
+	binary.LittleEndian.PutUint32(buf[1:], id)
+	if typ == frameData || typ == frameWindow {
+		buf = buf[:9]
+		binary.LittleEndian.PutUint32(buf[5:], arg)
+	}
+	buf = append(buf, data...)
+
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+
+	if _, err := m.conn.Write(buf); err != nil {
+		return err
+	}
+	return FlushMessage(m.conn)
+}
+
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err == nil {
+		m.err = err
+	}
+	m.cond.Broadcast()
+}
+
+// readLoop distributes the incoming frames. It never writes, so it can not deadlock with a writer waiting for the peer.
+func (m *Mux) readLoop() {
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(m.conn, hdr[:]); err != nil {
+			m.fail(err)
+			return
+		}
+		typ := hdr[0]
+		id := binary.LittleEndian.Uint32(hdr[1:])
+
+		var arg uint32
+		var data []byte
+		if typ == frameData || typ == frameWindow {
+			if err := binary.Read(m.conn, binary.LittleEndian, &arg); err != nil {
+				m.fail(err)
+				return
+			}
+		}
+		if typ == frameData {
+			if arg > muxMaxFrameLen {
+				m.fail(InvalidFrame)
+				return
+			}
+			data = make([]byte, arg)
+			if _, err := io.ReadFull(m.conn, data); err != nil {
+				m.fail(err)
+				return
+			}
+		}
+
+		if err := m.handleFrame(typ, id, arg, data); err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+func (m *Mux) handleFrame(typ byte, id uint32, arg uint32, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.cond.Broadcast()
+
+	ch, ok := m.chans[id]
+
+	switch typ {
+	case frameData, frameClose:
+		if m.ours(id) {
+			return InvalidChannel
+		}
+		if !ok {
+			if m.isFinished(id) {
+				return InvalidFrame // The stream was already closed
+			}
+			ch = m.newChan(id)
+			m.chans[id] = ch
+			if m.pending < muxMaxPending {
+				m.pending++
+			} else {
+				// Discard the data and tell the peer to stop. The read loop must not write, so this is done in the background.
+				ch.rejected = true
+				ch.rdone = true
+				go m.writeFrame(frameReset, id, 0, nil)
+			}
+		}
+		if ch.rclosed {
+			return InvalidFrame
+		}
+
+		if typ == frameClose {
+			ch.rclosed = true
+			if ch.rdone {
+				m.finish(id)
+			}
+			return nil
+		}
+
+		if ch.rdone {
+			return nil
+		}
+		if ch.buf.Len()+int(ch.consumed)+len(data) > muxWindow {
+			return InvalidFrame // Peer ignored the flow control window
+		}
+		ch.buf.Write(data)
+	case frameWindow:
+		if ok {
+			ch.credit += int(arg)
+		}
+	case frameReset:
+		if ok {
+			ch.reset = true
+		}
+	default:
+		return InvalidFrame
+	}
+
+	return nil
+}
+
+func (ch *muxChan) read(p []byte) (int, error) {
+	m := ch.m
+	m.mu.Lock()
+
+	for ch.buf.Len() == 0 && !ch.rclosed && m.err == nil && !ch.rdone {
+		m.cond.Wait()
+	}
+
+	if ch.buf.Len() == 0 {
+		defer m.mu.Unlock()
+		switch {
+		case ch.rdone:
+			return 0, io.ErrClosedPipe
+		case ch.rclosed:
+			if ch.id != muxMainChannel {
+				m.finish(ch.id)
+			}
+			return 0, io.EOF
+		case m.err == io.EOF && ch.id != muxMainChannel:
+			return 0, io.ErrUnexpectedEOF // The connection ended before the stream was closed
+		}
+		return 0, m.err
+	}
+
+	n, _ := ch.buf.Read(p)
+	ch.consumed += uint32(n)
+	var ack uint32
+	if ch.consumed >= muxWindow/4 {
+		ack = ch.consumed
+		ch.consumed = 0
+	}
+	m.mu.Unlock()
+
+	if ack > 0 {
+		if err := m.writeFrame(frameWindow, ch.id, ack, nil); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (ch *muxChan) write(p []byte) (int, error) {
+	m := ch.m
+	written := 0
+
+	for len(p) > 0 {
+		m.mu.Lock()
+		for ch.credit == 0 && m.err == nil && !ch.reset && !ch.wclosed {
+			m.cond.Wait()
+		}
+		switch {
+		case ch.wclosed:
+			m.mu.Unlock()
+			return written, io.ErrClosedPipe
+		case m.err != nil:
+			m.mu.Unlock()
+			return written, m.err
+		case ch.reset:
+			m.mu.Unlock()
+			return written, StreamReset
+		}
+
+		n := len(p)
+		if n > ch.credit {
+			n = ch.credit
+		}
+		if n > muxMaxFrameLen {
+			n = muxMaxFrameLen
+		}
+		ch.credit -= n
+		m.mu.Unlock()
+
+		if err := m.writeFrame(frameData, ch.id, uint32(n), p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// MuxStreamWriter is the sending side of a multiplexed stream.
+type MuxStreamWriter struct{ ch *muxChan }
+
+// ID returns the ID of the stream. The receiver needs it for AcceptStream.
+func (msw *MuxStreamWriter) ID() uint32 { return msw.ch.id }
+
+// Write implements io.Writer. It blocks, if the receiver did not yet consume enough data.
+// If the receiver closed the stream, StreamReset is returned.
+func (msw *MuxStreamWriter) Write(p []byte) (int, error) { return msw.ch.write(p) }
+
+// Close implements io.Closer. The reader will get an io.EOF after reading all data.
+// Writes after Close fail with io.ErrClosedPipe.
+func (msw *MuxStreamWriter) Close() error {
+	m := msw.ch.m
+	m.mu.Lock()
+	if msw.ch.wclosed {
+		m.mu.Unlock()
+		return nil
+	}
+	msw.ch.wclosed = true
+	delete(m.chans, msw.ch.id)
+	m.cond.Broadcast()
+	m.mu.Unlock()
+
+	return m.writeFrame(frameClose, msw.ch.id, 0, nil)
+}
+
+// MuxStreamReader is the receiving side of a multiplexed stream.
+type MuxStreamReader struct{ ch *muxChan }
+
+// ID returns the ID of the stream.
+func (msr *MuxStreamReader) ID() uint32 { return msr.ch.id }
+
+// Read implements io.Reader.
+func (msr *MuxStreamReader) Read(p []byte) (int, error) { return msr.ch.read(p) }
+
+// Close implements io.Closer. If the stream was not read completely, the sender is told to stop sending.
+func (msr *MuxStreamReader) Close() error {
+	ch := msr.ch
+	m := ch.m
+	m.mu.Lock()
+	if ch.rdone {
+		m.mu.Unlock()
+		return nil
+	}
+	ch.rdone = true
+	ch.buf.Reset()
+	finished := ch.rclosed
+	if finished {
+		m.finish(ch.id)
+	}
+	m.cond.Broadcast()
+	m.mu.Unlock()
+
+	if finished {
+		return nil
+	}
+	return m.writeFrame(frameReset, ch.id, 0, nil)
+}
@@ -0,0 +1,235 @@
+package binproto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMux(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connC.Close()
+	defer connS.Close()
+
+	muxC := NewMux(connC, true)
+	muxS := NewMux(connS, false)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4*muxWindow/16)
+
+	msw, err := muxC.OpenStream()
+	if err != nil {
+		t.Fatalf("Could not open stream: %s", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		// This will block until the server reads the stream, the main channel must still work.
+		if _, err := msw.Write(content); err != nil {
+			errs <- err
+			return
+		}
+		errs <- msw.Close()
+	}()
+
+	mainC := muxC.Main()
+	chkerr(t, InitEvent(mainC, 1), "InitEvent")
+	chkerr(t, SendNumber(mainC, int64(msw.ID())), "SendNumber")
+	chkerr(t, InitEvent(mainC, 2), "InitEvent")
+	chkerr(t, SendNil(mainC), "SendNil")
+
+	ur := NewSimpleUnitReader(muxS.Main())
+	readExpect2(t, ur, UTEvent)
+	id := readExpect2(t, ur, UTNumber).(int64)
+	readExpect2(t, ur, UTEvent)
+	readExpect2(t, ur, UTNil)
+
+	msr, err := muxS.AcceptStream(uint32(id))
+	if err != nil {
+		t.Fatalf("Could not accept stream: %s", err)
+	}
+	d, err := ioutil.ReadAll(msr)
+	if err != nil {
+		t.Fatalf("Could not read stream: %s", err)
+	}
+	if !bytes.Equal(d, content) {
+		t.Errorf("Wrong stream data, got %d bytes", len(d))
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Writing to stream failed: %s", err)
+	}
+
+	if _, err := muxS.AcceptStream(1 - uint32(id)%2); err != InvalidChannel {
+		t.Errorf("Expected InvalidChannel, got: %v", err)
+	}
+}
+
+func TestMuxReset(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connC.Close()
+	defer connS.Close()
+
+	muxC := NewMux(connC, true)
+	muxS := NewMux(connS, false)
+
+	msw, err := muxC.OpenStream()
+	if err != nil {
+		t.Fatalf("Could not open stream: %s", err)
+	}
+	msr, err := muxS.AcceptStream(msw.ID())
+	if err != nil {
+		t.Fatalf("Could not accept stream: %s", err)
+	}
+	if err := msr.Close(); err != nil {
+		t.Fatalf("Could not close stream reader: %s", err)
+	}
+
+	if _, err := msw.Write(make([]byte, 2*muxWindow)); err != StreamReset {
+		t.Errorf("Expected StreamReset, got: %v", err)
+	}
+}
+
+func TestMuxAcceptTwice(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connC.Close()
+	defer connS.Close()
+
+	muxC := NewMux(connC, true)
+	muxS := NewMux(connS, false)
+
+	msw, err := muxC.OpenStream()
+	if err != nil {
+		t.Fatalf("Could not open stream: %s", err)
+	}
+	chkerr(t, msw.Close(), "Close")
+
+	msr, err := muxS.AcceptStream(msw.ID())
+	if err != nil {
+		t.Fatalf("Could not accept stream: %s", err)
+	}
+	if _, err := muxS.AcceptStream(msw.ID()); err != StreamAccepted {
+		t.Errorf("Open stream: expected StreamAccepted, got: %v", err)
+	}
+	if _, err := ioutil.ReadAll(msr); err != nil {
+		t.Fatalf("Could not read stream: %s", err)
+	}
+	if _, err := muxS.AcceptStream(msw.ID()); err != StreamAccepted {
+		t.Errorf("Finished stream: expected StreamAccepted, got: %v", err)
+	}
+}
+
+func TestMuxTooManyStreams(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connC.Close()
+	defer connS.Close()
+
+	muxC := NewMux(connC, true)
+	muxS := NewMux(connS, false)
+
+	var msw *MuxStreamWriter
+	var first uint32
+	for i := 0; i <= muxMaxPending; i++ {
+		var err error
+		if msw, err = muxC.OpenStream(); err != nil {
+			t.Fatalf("Could not open stream: %s", err)
+		}
+		if i == 0 {
+			first = msw.ID()
+		}
+		if _, err := msw.Write([]byte{1}); err != nil {
+			t.Fatalf("Could not write to stream: %s", err)
+		}
+	}
+
+	// The last stream is reset, the reset frame arrives asynchronously.
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		_, err = msw.Write([]byte{1})
+		time.Sleep(time.Millisecond)
+	}
+	if err != StreamReset {
+		t.Errorf("Expected StreamReset, got: %v", err)
+	}
+	if _, err := muxS.AcceptStream(msw.ID()); err != TooManyStreams {
+		t.Errorf("Expected TooManyStreams, got: %v", err)
+	}
+
+	// The other streams and the connection still work.
+	msr, err := muxS.AcceptStream(first)
+	if err != nil {
+		t.Fatalf("Could not accept stream: %s", err)
+	}
+	if n, err := msr.Read(make([]byte, 1)); n != 1 || err != nil {
+		t.Errorf("Could not read from accepted stream: %d, %v", n, err)
+	}
+	chkerr(t, SendNumber(muxC.Main(), 7), "SendNumber")
+	if n := readExpect2(t, NewSimpleUnitReader(muxS.Main()), UTNumber).(int64); n != 7 {
+		t.Errorf("Wrong number: %d", n)
+	}
+}
+
+func TestMuxTruncatedStream(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connS.Close()
+
+	muxC := NewMux(connC, true)
+	muxS := NewMux(connS, false)
+
+	msw, err := muxC.OpenStream()
+	if err != nil {
+		t.Fatalf("Could not open stream: %s", err)
+	}
+	if _, err := msw.Write([]byte("partial")); err != nil {
+		t.Fatalf("Could not write to stream: %s", err)
+	}
+	msr, err := muxS.AcceptStream(msw.ID())
+	if err != nil {
+		t.Fatalf("Could not accept stream: %s", err)
+	}
+	connC.Close()
+
+	d, err := ioutil.ReadAll(msr)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got: %v", err)
+	}
+	if string(d) != "partial" {
+		t.Errorf("Wrong data: %q", d)
+	}
+	if _, err := muxS.Main().Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected io.EOF from the main channel, got: %v", err)
+	}
+}
+
+func TestMuxWriteAfterClose(t *testing.T) {
+	connC, connS := net.Pipe()
+	defer connC.Close()
+	defer connS.Close()
+
+	muxC := NewMux(connC, true)
+	muxS := NewMux(connS, false)
+
+	msw, err := muxC.OpenStream()
+	if err != nil {
+		t.Fatalf("Could not open stream: %s", err)
+	}
+	chkerr(t, msw.Close(), "Close")
+	if _, err := msw.Write([]byte("late")); err != io.ErrClosedPipe {
+		t.Errorf("Expected io.ErrClosedPipe, got: %v", err)
+	}
+	chkerr(t, msw.Close(), "Close")
+
+	// The connection must still work.
+	msr, err := muxS.AcceptStream(msw.ID())
+	if err != nil {
+		t.Fatalf("Could not accept stream: %s", err)
+	}
+	if d, err := ioutil.ReadAll(msr); err != nil || len(d) != 0 {
+		t.Errorf("Unexpected stream content: %q (err: %v)", d, err)
+	}
+	chkerr(t, SendNumber(muxC.Main(), 7), "SendNumber")
+	if n := readExpect2(t, NewSimpleUnitReader(muxS.Main()), UTNumber).(int64); n != 7 {
+		t.Errorf("Wrong number: %d", n)
+	}
+}
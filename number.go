@@ -0,0 +1,73 @@
+package binproto
+
+import (
+	"errors"
+	"math"
+	"math/big"
+)
+
+// Errors of the number conversions.
+var (
+	NumberOverflow = errors.New("Number does not fit into the requested type")
+	InvalidBigInt  = errors.New("Invalid BigInt unit received")
+)
+
+var (
+	minInt64  = big.NewInt(math.MinInt64)
+	maxInt64  = big.NewInt(math.MaxInt64)
+	maxUint64 = new(big.Int).SetUint64(math.MaxUint64)
+)
+
+// AsInt64 converts the payload of a Number, Uint64 or BigInt unit to an int64.
+// If the value does not fit, NumberOverflow is returned. Other payloads result in UnexpectedUnit.
+func AsInt64(data interface{}) (int64, error) {
+	switch n := data.(type) {
+	case int64:
+		return n, nil
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, NumberOverflow
+		}
+		return int64(n), nil
+	case *big.Int:
+		if n.Cmp(minInt64) < 0 || n.Cmp(maxInt64) > 0 {
+			return 0, NumberOverflow
+		}
+		return n.Int64(), nil
+	}
+	return 0, UnexpectedUnit
+}
+
+// AsUint64 converts the payload of a Number, Uint64 or BigInt unit to a uint64.
+// If the value does not fit (e.g. because it is negative), NumberOverflow is returned. Other payloads result in UnexpectedUnit.
+func AsUint64(data interface{}) (uint64, error) {
+	switch n := data.(type) {
+	case int64:
+		if n < 0 {
+			return 0, NumberOverflow
+		}
+		return uint64(n), nil
+	case uint64:
+		return n, nil
+	case *big.Int:
+		if n.Sign() < 0 || n.Cmp(maxUint64) > 0 {
+			return 0, NumberOverflow
+		}
+		return n.Uint64(), nil
+	}
+	return 0, UnexpectedUnit
+}
+
+// AsBigInt converts the payload of a Number, Uint64 or BigInt unit to a *big.Int. A BigInt payload is copied.
+// Other payloads result in UnexpectedUnit.
+func AsBigInt(data interface{}) (*big.Int, error) {
+	switch n := data.(type) {
+	case int64:
+		return big.NewInt(n), nil
+	case uint64:
+		return new(big.Int).SetUint64(n), nil
+	case *big.Int:
+		return new(big.Int).Set(n), nil
+	}
+	return nil, UnexpectedUnit
+}
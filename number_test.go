@@ -0,0 +1,69 @@
+package binproto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestUint64AndBigInt(t *testing.T) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+
+	w := new(bytes.Buffer)
+	chkerr(t, SendUint64(w, math.MaxUint64), "SendUint64")
+	chkerr(t, SendBigInt(w, huge), "SendBigInt")
+	chkerr(t, SendBigInt(w, new(big.Int)), "SendBigInt")
+
+	vw := NewValidatingWriter(ioutil.Discard)
+	if _, err := vw.Write(w.Bytes()); err != nil {
+		t.Errorf("Validation failed: %s", err)
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if n := readExpect2(t, ur, UTUint64).(uint64); n != math.MaxUint64 {
+		t.Errorf("Wrong uint64: %d", n)
+	}
+	if n := readExpect2(t, ur, UTBigInt).(*big.Int); n.Cmp(huge) != 0 {
+		t.Errorf("Wrong BigInt: %s", n)
+	}
+	if n := readExpect2(t, ur, UTBigInt).(*big.Int); n.Sign() != 0 {
+		t.Errorf("Wrong BigInt: %s", n)
+	}
+}
+
+func TestNumberConversions(t *testing.T) {
+	big63 := new(big.Int).Lsh(big.NewInt(1), 63)
+
+	tests := []struct {
+		data    interface{}
+		i64     int64
+		i64Err  error
+		u64     uint64
+		u64Err  error
+		wantBig string
+	}{
+		{int64(-1), -1, nil, 0, NumberOverflow, "-1"},
+		{uint64(1 << 63), 0, NumberOverflow, 1 << 63, nil, "9223372036854775808"},
+		{big63, 0, NumberOverflow, 1 << 63, nil, "9223372036854775808"},
+		{new(big.Int).Lsh(big63, 1), 0, NumberOverflow, 0, NumberOverflow, "18446744073709551616"},
+		{big.NewInt(math.MinInt64), math.MinInt64, nil, 0, NumberOverflow, "-9223372036854775808"},
+	}
+
+	for _, test := range tests {
+		if i64, err := AsInt64(test.data); i64 != test.i64 || err != test.i64Err {
+			t.Errorf("AsInt64(%v) = %d, %v", test.data, i64, err)
+		}
+		if u64, err := AsUint64(test.data); u64 != test.u64 || err != test.u64Err {
+			t.Errorf("AsUint64(%v) = %d, %v", test.data, u64, err)
+		}
+		if n, err := AsBigInt(test.data); err != nil || n.String() != test.wantBig {
+			t.Errorf("AsBigInt(%v) = %s, %v", test.data, n, err)
+		}
+	}
+
+	if _, err := AsInt64([]byte("1")); err != UnexpectedUnit {
+		t.Errorf("Expected UnexpectedUnit, got: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package binproto
+
+import (
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimal time between two calls of a ProgressFunc (except for the final one).
+const progressInterval = 100 * time.Millisecond
+
+// Progress describes the state of a BinStream transfer.
+type Progress struct {
+	Bytes int64         // Transferred bytes so far
+	Total int64         // Declared size of the stream, -1 if unknown
+	Rate  float64       // Average rate in bytes per second
+	ETA   time.Duration // Estimated remaining time, -1 if unknown
+	Done  bool          // Set for the final report at the end of the stream
+}
+
+// ProgressFunc is called by MeteredReader and MeteredWriter to report the progress.
+type ProgressFunc func(Progress)
+
+// RateLimiter limits the bandwidth with a token bucket.
+// Share a RateLimiter between multiple streams to limit their combined bandwidth (e.g. per connection).
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second, 0 if unlimited
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter that allows bytesPerSecond bytes per second on average and bursts of up to burst bytes.
+// If bytesPerSecond is <= 0, the bandwidth is not limited. A burst < 1 is treated as 1.
+func NewRateLimiter(bytesPerSecond, burst int) *RateLimiter {
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now()}
+}
+
+// Wait blocks until n bytes may be transferred. n should not be larger than the burst size.
+func (rl *RateLimiter) Wait(n int) {
+	if rl.rate == 0 {
+		return
+	}
+
+	rl.mu.Lock()
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > float64(rl.burst) {
+		rl.tokens = float64(rl.burst)
+	}
+	rl.last = now
+
+	// The tokens may become negative, which reserves them for us. Later callers have to wait longer.
+	rl.tokens -= float64(n)
+	var wait time.Duration
+	if rl.tokens < 0 {
+		wait = time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+	}
+	rl.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+type meter struct {
+	fn         ProgressFunc
+	lim        *RateLimiter
+	start      time.Time
+	lastReport time.Time
+	bytes      int64
+	done       bool
+}
+
+func newMeter(fn ProgressFunc, lim *RateLimiter) meter {
+	now := time.Now()
+	return meter{fn: fn, lim: lim, start: now, lastReport: now}
+}
+
+// maxChunk returns how many bytes may be transferred at once.
+func (m *meter) maxChunk(n int) int {
+	if m.lim != nil && m.lim.rate > 0 && n > m.lim.burst {
+		return m.lim.burst
+	}
+	return n
+}
+
+func (m *meter) add(n int, total int64, done bool) {
+	m.bytes += int64(n)
+	if m.fn == nil || m.done {
+		return
+	}
+
+	now := time.Now()
+	if !done && now.Sub(m.lastReport) < progressInterval {
+		return
+	}
+	m.lastReport = now
+	m.done = done
+
+	p := Progress{Bytes: m.bytes, Total: total, ETA: -1, Done: done}
+	if elapsed := now.Sub(m.start).Seconds(); elapsed > 0 {
+		p.Rate = float64(m.bytes) / elapsed
+	}
+	if total >= 0 && p.Rate > 0 {
+		p.ETA = time.Duration(float64(total-m.bytes) / p.Rate * float64(time.Second))
+	}
+	m.fn(p)
+}
+
+// MeteredReader wraps a BinstreamReader, reports the progress and limits the bandwidth.
+type MeteredReader struct {
+	bsr *BinstreamReader
+	m   meter
+}
+
+// NewMeteredReader wraps bsr. fn and lim can be nil.
+func NewMeteredReader(bsr *BinstreamReader, fn ProgressFunc, lim *RateLimiter) *MeteredReader {
+	return &MeteredReader{bsr, newMeter(fn, lim)}
+}
+
+// Read implements io.Reader.
+func (mr *MeteredReader) Read(p []byte) (int, error) {
+	n, err := mr.bsr.Read(p[:mr.m.maxChunk(len(p))])
+	if mr.m.lim != nil && n > 0 {
+		mr.m.lim.Wait(n)
+	}
+
+	total, _ := mr.bsr.Size()
+	mr.m.add(n, total, err != nil)
+	return n, err
+}
+
+// FastForward skips to the end of the stream, see BinstreamReader.FastForward. The skipped data is not metered.
+func (mr *MeteredReader) FastForward() error {
+	return mr.bsr.FastForward()
+}
+
+// MeteredWriter wraps a BinstreamWriter, reports the progress and limits the bandwidth.
+type MeteredWriter struct {
+	bsw *BinstreamWriter
+	m   meter
+}
+
+// NewMeteredWriter wraps bsw. fn and lim can be nil.
+func NewMeteredWriter(bsw *BinstreamWriter, fn ProgressFunc, lim *RateLimiter) *MeteredWriter {
+	return &MeteredWriter{bsw, newMeter(fn, lim)}
+}
+
+// Write implements io.Writer.
+func (mw *MeteredWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		l := mw.m.maxChunk(len(p))
+		if mw.m.lim != nil {
+			mw.m.lim.Wait(l)
+		}
+
+		n, err := mw.bsw.Write(p[:l])
+		written += n
+		mw.m.add(n, mw.bsw.size, false)
+		if err != nil {
+			return written, err
+		}
+		p = p[l:]
+	}
+	return written, nil
+}
+
+// Close closes the BinstreamWriter and sends the final progress report.
+func (mw *MeteredWriter) Close() error {
+	err := mw.bsw.Close()
+	mw.m.add(0, mw.bsw.size, true)
+	return err
+}
+
+// Abort aborts the stream, see BinstreamWriter.Abort.
+func (mw *MeteredWriter) Abort(reason error) error {
+	err := mw.bsw.Abort(reason)
+	mw.m.add(0, mw.bsw.size, true)
+	return err
+}
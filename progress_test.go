@@ -0,0 +1,66 @@
+package binproto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestMeteredBinstream(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 10000)
+
+	var writeReports, readReports []Progress
+
+	w := new(bytes.Buffer)
+	bsw, err := InitBinStreamWithOptions(w, BinstreamOptions{Size: int64(len(content))})
+	if err != nil {
+		t.Fatalf("Could not init a BinstreamWriter: %s", err)
+	}
+	mw := NewMeteredWriter(bsw, func(p Progress) { writeReports = append(writeReports, p) }, NewRateLimiter(100000, 1000))
+
+	start := time.Now()
+	if _, err := io.Copy(mw, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Could not write to MeteredWriter: %s", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Could not close MeteredWriter: %s", err)
+	}
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("Bandwidth was not limited, writing took only %s", d)
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	mr := NewMeteredReader(readExpect2(t, ur, UTBinStream).(*BinstreamReader), func(p Progress) { readReports = append(readReports, p) }, nil)
+	d, err := ioutil.ReadAll(mr)
+	if err != nil {
+		t.Fatalf("Could not read from MeteredReader: %s", err)
+	}
+	if !bytes.Equal(d, content) {
+		t.Errorf("Wrong Binstream data, got %d bytes", len(d))
+	}
+
+	for _, reports := range [][]Progress{writeReports, readReports} {
+		if len(reports) == 0 {
+			t.Fatal("No progress reported")
+		}
+		last := reports[len(reports)-1]
+		if !last.Done || last.Bytes != int64(len(content)) || last.Total != int64(len(content)) || last.ETA != 0 {
+			t.Errorf("Wrong final report: %+v", last)
+		}
+	}
+}
+
+func TestUnlimitedRateLimiter(t *testing.T) {
+	for _, rate := range []int{0, -1} {
+		rl := NewRateLimiter(rate, 0)
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			rl.Wait(1 << 20)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Rate %d: waited %s", rate, d)
+		}
+	}
+}
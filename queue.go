@@ -0,0 +1,229 @@
+package binproto
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Priority classes of SendQueue.
+type Priority int
+
+const (
+	PrioAnswer Priority = iota // Answers to requests, a peer is probably waiting for them
+	PrioEvent                  // Requests and events
+	PrioBulk                   // Large transfers, e.g. BinStreams
+
+	NumPriorities = iota // Number of priority classes, the length of the arrays in QueueConfig
+)
+
+func (p Priority) valid() bool {
+	return p >= 0 && p < NumPriorities
+}
+
+// Scheduling selects how SendQueue chooses the next message.
+type Scheduling int
+
+const (
+	// StrictPriority always sends the message of the highest priority class first. Lower classes may starve.
+	StrictPriority Scheduling = iota
+	// WeightedRoundRobin sends up to Weights[p] messages of class p, before it continues with the next class.
+	WeightedRoundRobin
+)
+
+// Errors of SendQueue.
+var (
+	QueueFull       = errors.New("Send queue is full")
+	QueueClosed     = errors.New("Send queue is closed")
+	InvalidPriority = errors.New("Invalid priority class")
+)
+
+const defaultQueueCapacity = 64
+
+var defaultWeights = [NumPriorities]int{8, 4, 1}
+
+// QueueConfig configures a SendQueue. The zero value is usable.
+type QueueConfig struct {
+	Capacity   [NumPriorities]int // Maximum number of queued messages per class. Defaults to 64.
+	Scheduling Scheduling
+	Weights    [NumPriorities]int // Only for WeightedRoundRobin. Defaults to 8, 4, 1.
+}
+
+// SendFunc writes one complete message.
+type SendFunc func(w io.Writer) error
+
+// SendQueue sends messages asynchronously in its own goroutine.
+// Every priority class has its own bounded queue, so e.g. answers don't have to wait for a flood of events.
+//
+// A message is written completely by its SendFunc, before the next one starts. After every message, the writer is flushed (see FlushMessage).
+// If a SendFunc fails, the queue stops and all further calls return that error.
+type SendQueue struct {
+	w        io.Writer
+	conf     QueueConfig
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	queues   [NumPriorities][]SendFunc
+	closed   bool
+	err      error
+	done     chan struct{}
+
+	// Weighted round robin state
+	cur  Priority
+	sent int
+}
+
+// NewSendQueue creates a SendQueue that writes to w and starts the sender goroutine.
+func NewSendQueue(w io.Writer, conf QueueConfig) *SendQueue {
+	for p := range conf.Capacity {
+		if conf.Capacity[p] < 1 {
+			conf.Capacity[p] = defaultQueueCapacity
+		}
+		if conf.Weights[p] < 1 {
+			conf.Weights[p] = defaultWeights[p]
+		}
+	}
+
+	q := &SendQueue{w: w, conf: conf, done: make(chan struct{})}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+
+	go q.run()
+	return q
+}
+
+func (q *SendQueue) enqueue(prio Priority, fn SendFunc, block bool) error {
+	if !prio.valid() {
+		return InvalidPriority
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		switch {
+		case q.err != nil:
+			return q.err
+		case q.closed:
+			return QueueClosed
+		case len(q.queues[prio]) < q.conf.Capacity[prio]:
+			q.queues[prio] = append(q.queues[prio], fn)
+			q.notEmpty.Signal()
+			return nil
+		case !block:
+			return QueueFull
+		}
+		q.notFull.Wait()
+	}
+}
+
+// Enqueue queues a message. If the queue of the class is full, it blocks until there is room again.
+// An unknown class is rejected with InvalidPriority.
+func (q *SendQueue) Enqueue(prio Priority, fn SendFunc) error {
+	return q.enqueue(prio, fn, true)
+}
+
+// TryEnqueue queues a message. If the queue of the class is full, QueueFull is returned.
+// An unknown class is rejected with InvalidPriority.
+func (q *SendQueue) TryEnqueue(prio Priority, fn SendFunc) error {
+	return q.enqueue(prio, fn, false)
+}
+
+// Len returns the number of queued messages of a class. An unknown class is rejected with InvalidPriority.
+func (q *SendQueue) Len(prio Priority) (int, error) {
+	if !prio.valid() {
+		return 0, InvalidPriority
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[prio]), nil
+}
+
+func (q *SendQueue) pop(prio Priority) SendFunc {
+	fn := q.queues[prio][0]
+	q.queues[prio][0] = nil
+	q.queues[prio] = q.queues[prio][1:]
+	return fn
+}
+
+// next chooses the next message. At least one queue must not be empty.
+func (q *SendQueue) next() SendFunc {
+	if q.conf.Scheduling == StrictPriority {
+		for prio := range q.queues {
+			if len(q.queues[prio]) > 0 {
+				return q.pop(Priority(prio))
+			}
+		}
+	}
+
+	for {
+		if len(q.queues[q.cur]) > 0 && q.sent < q.conf.Weights[q.cur] {
+			q.sent++
+			return q.pop(q.cur)
+		}
+		q.cur = (q.cur + 1) % NumPriorities
+		q.sent = 0
+	}
+}
+
+func (q *SendQueue) empty() bool {
+	for _, queue := range q.queues {
+		if len(queue) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *SendQueue) run() {
+	defer close(q.done)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for q.empty() && !q.closed {
+			q.notEmpty.Wait()
+		}
+		if q.empty() {
+			return
+		}
+
+		fn := q.next()
+		q.notFull.Broadcast()
+		q.mu.Unlock()
+
+		err := fn(q.w)
+		if err == nil {
+			err = FlushMessage(q.w)
+		}
+
+		q.mu.Lock()
+		if err != nil {
+			q.err = err
+			q.queues = [NumPriorities][]SendFunc{}
+			q.notFull.Broadcast()
+			return
+		}
+	}
+}
+
+// Close sends the remaining messages and stops the sender goroutine. It returns the error of a failed SendFunc.
+func (q *SendQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.notEmpty.Signal()
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+
+	<-q.done
+	return q.Err()
+}
+
+// Err returns the error of a failed SendFunc.
+func (q *SendQueue) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
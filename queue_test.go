@@ -0,0 +1,136 @@
+package binproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// fillQueue blocks the sender with a first message, so the following ones are queued.
+// The returned function releases the sender.
+func fillQueue(t *testing.T, q *SendQueue, msgs map[Priority][]int64) func() {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	chkerr(t, q.Enqueue(PrioAnswer, func(w io.Writer) error {
+		close(started)
+		<-release
+		return SendNumber(w, -1)
+	}), "Enqueue")
+	<-started
+
+	for prio, nums := range msgs {
+		for _, n := range nums {
+			n := n
+			chkerr(t, q.TryEnqueue(prio, func(w io.Writer) error { return SendNumber(w, n) }), "TryEnqueue")
+		}
+	}
+	return func() { close(release) }
+}
+
+func readNumbers(t *testing.T, r io.Reader) []int64 {
+	var nums []int64
+	ur := NewSimpleUnitReader(r)
+	for {
+		ut, data, err := ur.ReadUnit()
+		if err == io.EOF {
+			return nums
+		}
+		if err != nil {
+			t.Fatalf("Could not read unit: %s", err)
+		}
+		chkUnitType(t, ut, UTNumber)
+		nums = append(nums, data.(int64))
+	}
+}
+
+func chkNumbers(t *testing.T, got, want []int64) {
+	if len(got) != len(want) {
+		t.Fatalf("Wrong messages. got %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("Wrong messages. got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueStrict(t *testing.T) {
+	w := new(bytes.Buffer)
+	q := NewSendQueue(w, QueueConfig{})
+	release := fillQueue(t, q, map[Priority][]int64{
+		PrioBulk:   {31, 32},
+		PrioEvent:  {21, 22},
+		PrioAnswer: {11, 12}})
+	release()
+	chkerr(t, q.Close(), "Close")
+
+	chkNumbers(t, readNumbers(t, w), []int64{-1, 11, 12, 21, 22, 31, 32})
+
+	if err := q.Enqueue(PrioEvent, func(io.Writer) error { return nil }); err != QueueClosed {
+		t.Errorf("Expected QueueClosed, got: %v", err)
+	}
+}
+
+func TestSendQueueWeighted(t *testing.T) {
+	w := new(bytes.Buffer)
+	q := NewSendQueue(w, QueueConfig{Scheduling: WeightedRoundRobin, Weights: [NumPriorities]int{2, 1, 1}})
+	release := fillQueue(t, q, map[Priority][]int64{
+		PrioBulk:   {31, 32},
+		PrioEvent:  {21, 22, 23},
+		PrioAnswer: {11, 12, 13, 14, 15}})
+	release()
+	chkerr(t, q.Close(), "Close")
+
+	// The blocking message already used one slot of the answer class.
+	chkNumbers(t, readNumbers(t, w), []int64{-1, 11, 21, 31, 12, 13, 22, 32, 14, 15, 23})
+}
+
+func TestSendQueueBackpressure(t *testing.T) {
+	w := new(bytes.Buffer)
+	q := NewSendQueue(w, QueueConfig{Capacity: [NumPriorities]int{1, 1, 1}})
+	release := fillQueue(t, q, map[Priority][]int64{PrioEvent: {1}})
+
+	if err := q.TryEnqueue(PrioEvent, func(w io.Writer) error { return SendNumber(w, 2) }); err != QueueFull {
+		t.Errorf("Expected QueueFull, got: %v", err)
+	}
+
+	enqueued := make(chan error)
+	go func() {
+		enqueued <- q.Enqueue(PrioEvent, func(w io.Writer) error { return SendNumber(w, 2) })
+	}()
+	release()
+	chkerr(t, <-enqueued, "Enqueue")
+	chkerr(t, q.Close(), "Close")
+
+	chkNumbers(t, readNumbers(t, w), []int64{-1, 1, 2})
+}
+
+func TestSendQueueError(t *testing.T) {
+	q := NewSendQueue(new(bytes.Buffer), QueueConfig{})
+	fail := errors.New("connection lost")
+
+	chkerr(t, q.Enqueue(PrioEvent, func(io.Writer) error { return fail }), "Enqueue")
+	if err := q.Close(); err != fail {
+		t.Errorf("Expected the error of the SendFunc, got: %v", err)
+	}
+}
+
+func TestSendQueueInvalidPriority(t *testing.T) {
+	q := NewSendQueue(new(bytes.Buffer), QueueConfig{})
+	for _, prio := range []Priority{-1, NumPriorities} {
+		if err := q.Enqueue(prio, func(io.Writer) error { return nil }); err != InvalidPriority {
+			t.Errorf("Enqueue(%d): expected InvalidPriority, got: %v", prio, err)
+		}
+		if err := q.TryEnqueue(prio, func(io.Writer) error { return nil }); err != InvalidPriority {
+			t.Errorf("TryEnqueue(%d): expected InvalidPriority, got: %v", prio, err)
+		}
+		if _, err := q.Len(prio); err != InvalidPriority {
+			t.Errorf("Len(%d): expected InvalidPriority, got: %v", prio, err)
+		}
+	}
+	if n, err := q.Len(PrioBulk); n != 0 || err != nil {
+		t.Errorf("Len(PrioBulk): %d, %v", n, err)
+	}
+	chkerr(t, q.Close(), "Close")
+}
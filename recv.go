@@ -4,33 +4,77 @@ import (
 	"encoding/binary"
 	"github.com/silvasur/kagus"
 	"io"
+	"math"
+	"math/big"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // UnitReader is an interface with the ReadUnit function, which ist the basic reading function of the binproto.
 // ReadUnit reads the next binproto unit from the reader. The second output has a different meaning for each unit type:
 //
-//     UTRequest, UTAnswer, UTEvent - uint16
-//     UTBin                        - []byte
-//     UTNumber                     - int64
-//     UTUKey, UTByte               - byte
-//     UTBinStream                  - *BinStreamReader
-//     UTBool                       - bool
+//	UTRequest, UTAnswer, UTEvent   - uint16
+//	UTBin                          - []byte
+//	UTString                       - string
+//	UTNumber                       - int64
+//	UTUint64                       - uint64
+//	UTBigInt                       - *big.Int
+//	UTFloat                        - float64
+//	UTUKey, UTByte                 - byte
+//	UTList, UTTextKVMap, UTIdKVMap - nil or *SizedContainer
+//	UTBinStream                    - *BinStreamReader
+//	UTArray                        - *ArrayReader
+//	UTBool                         - bool
+//	UTTime                         - time.Time
+//	UTDuration                     - time.Duration
 //
-// A UnitReader implementation should usually wrap the SimpleUnitReader implementation.
+// Extension units (see RegisterUnitType) are decoded by their codec. For unregistered extension types, the raw payload
+// ([]byte) is returned together with UnknownUnit, decoding errors are returned as *ExtDecodeError. The unit was consumed anyway,
+// so reading can continue.
+//
+// Sized units are returned as the container they contain, with a *SizedContainer payload.
+//
+// The compact unit types (UTCompactRequest, ...) are returned as their standard counterparts (UTRequest, ...).
+// The string table units are resolved, UTStrDef and UTStrRef are returned as UTBin.
+//
+// A UnitReader implementation should usually wrap the SimpleUnitReader implementation. If it embeds the *SimpleUnitReader,
+// SkipUnit can skip Sized units without parsing them.
 type UnitReader interface {
 	ReadUnit() (UnitType, interface{}, error)
 }
 
+// lazyPayload tracks the payload of a unit that is read after ReadUnit returned (BinStream, Array).
+type lazyPayload struct {
+	done   chan struct{} // Closed, when the payload was read completely or reading it failed
+	broken error         // Set before done is closed, if the underlying stream is not usable any more
+}
+
+func newLazyPayload() lazyPayload {
+	return lazyPayload{done: make(chan struct{})}
+}
+
+// Done returns a channel that will be closed, when the payload was read completely or reading it failed.
+// From then on, the parent SimpleUnitReader can be used again.
+func (lp *lazyPayload) Done() <-chan struct{} {
+	return lp.done
+}
+
 // SimpleUnitReader is a UnitReader implementation that gets its data from an io.Reader.
+//
+// After a UTBinStream or UTArray was read, its reader must be read to the end (or closed), before ReadUnit can continue.
+// Until then, ReadUnit returns ErrStreamOpen. The Done method of the reader can be used to wait for that.
 type SimpleUnitReader struct {
-	r  io.Reader
-	mu *sync.Mutex
+	r       *offsetReader
+	mu      *sync.Mutex
+	open    *lazyPayload // The payload of the last BinStream or Array
+	err     error        // Set, if a payload broke the underlying stream
+	strings [][]byte     // String table, see StringTableWriter
 }
 
 func NewSimpleUnitReader(r io.Reader) *SimpleUnitReader {
 	return &SimpleUnitReader{
-		r:  r,
+		r:  &offsetReader{r: r},
 		mu: new(sync.Mutex)}
 }
 
@@ -38,19 +82,38 @@ func (sur *SimpleUnitReader) ReadUnit() (UnitType, interface{}, error) {
 	r := sur.r
 
 	sur.mu.Lock()
-	doUnlock := true
-	defer func() {
-		if doUnlock {
-			sur.mu.Unlock()
+	defer sur.mu.Unlock()
+
+	if sur.open != nil {
+		select {
+		case <-sur.open.done:
+			sur.err = sur.open.broken
+			sur.open = nil
+		default:
+			return 0, nil, ErrStreamOpen
 		}
-	}()
+	}
+	if sur.err != nil {
+		return 0, nil, sur.err
+	}
 
 	_ut, err := kagus.ReadByte(r)
 	if err != nil {
 		return 0, nil, err
 	}
+	for UnitType(_ut) == UTStrReset {
+		sur.strings = nil
+		if _ut, err = kagus.ReadByte(r); err != nil {
+			return 0, nil, err
+		}
+	}
 
 	ut := UnitType(_ut)
+	if isExt(ut) {
+		data, err := readExt(r, ut)
+		return ut, data, err
+	}
+
 	switch ut {
 	case UTNil:
 		return ut, nil, nil
@@ -71,20 +134,98 @@ func (sur *SimpleUnitReader) ReadUnit() (UnitType, interface{}, error) {
 			return ut, nil, err
 		}
 		return ut, buf, nil
+	case UTString:
+		var l uint32
+		if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+			return ut, nil, err
+		}
+		buf := make([]byte, l)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return ut, nil, err
+		}
+		if !utf8.Valid(buf) {
+			return ut, nil, InvalidUTF8 // The unit was read completely, so the stream is still usable.
+		}
+		return ut, string(buf), nil
 	case UTNumber:
 		var n int64
 		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
 			return ut, nil, err
 		}
 		return ut, n, nil
+	case UTUint64:
+		var n uint64
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return ut, nil, err
+		}
+		return ut, n, nil
+	case UTBigInt:
+		n, err := readBigInt(r)
+		return ut, n, err
+	case UTFloat:
+		var f float64
+		if err := binary.Read(r, binary.LittleEndian, &f); err != nil {
+			return ut, nil, err
+		}
+		return ut, f, nil
+	case UTCompactRequest, UTCompactAnswer, UTCompactEvent:
+		code, err := readUvarint(r, math.MaxUint16)
+		if err != nil {
+			return compactToStandard(ut), nil, err
+		}
+		return compactToStandard(ut), uint16(code), nil
+	case UTCompactBin:
+		l, err := readUvarint(r, math.MaxUint32)
+		if err != nil {
+			return UTBin, nil, err
+		}
+		buf := make([]byte, l)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return UTBin, nil, err
+		}
+		return UTBin, buf, nil
+	case UTStrDef:
+		s, err := sur.readStrDef()
+		return UTBin, s, err
+	case UTStrRef:
+		s, err := sur.readStrRef()
+		return UTBin, s, err
+	case UTCompactNumber:
+		n, err := readVarint(r)
+		if err != nil {
+			return UTNumber, nil, err
+		}
+		return UTNumber, n, nil
+	case UTTime:
+		t, err := readTime(r)
+		return ut, t, err
+	case UTDuration:
+		var d int64
+		if err := binary.Read(r, binary.LittleEndian, &d); err != nil {
+			return ut, nil, err
+		}
+		return ut, time.Duration(d), nil
 	case UTList, UTTextKVMap, UTIdKVMap:
 		return ut, nil, nil
+	case UTSized:
+		return sur.readSized()
 	case UTUKey, UTByte:
 		k, err := kagus.ReadByte(r)
 		return ut, k, err
 	case UTBinStream:
-		doUnlock = false
-		return ut, &BinstreamReader{r: r, surMu: sur.mu}, nil
+		bsr := newBinstreamReader(r)
+		sur.open = &bsr.lazyPayload
+		return ut, bsr, nil
+	case UTArray:
+		ar, err := newArrayReader(r)
+		if err != nil {
+			if err == UnknownArrayKind {
+				sur.err = err // The length of the data is unknown, so the stream is not usable any more.
+			}
+			return ut, nil, err
+		}
+		sur.open = &ar.lazyPayload
+		return ut, ar, nil
 	case UTTerm:
 		return ut, nil, nil
 	case UTBool:
@@ -99,6 +240,68 @@ func (sur *SimpleUnitReader) ReadUnit() (UnitType, interface{}, error) {
 	return ut, nil, UnknownUnit
 }
 
+func (sur *SimpleUnitReader) simple() *SimpleUnitReader { return sur }
+
+// simpleReader returns the SimpleUnitReader of ur, if ur is one or wraps one by embedding. Otherwise nil is returned.
+func simpleReader(ur UnitReader) *SimpleUnitReader {
+	if s, ok := ur.(interface{ simple() *SimpleUnitReader }); ok {
+		return s.simple()
+	}
+	return nil
+}
+
+func readBigInt(r io.Reader) (*big.Int, error) {
+	var hdr struct {
+		Sign uint8
+		Len  uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, hdr.Len)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	n := new(big.Int).SetBytes(buf)
+	switch hdr.Sign {
+	case 0:
+	case 1:
+		n.Neg(n)
+	default:
+		return nil, InvalidBigInt // The unit was read completely, so the stream is still usable.
+	}
+	return n, nil
+}
+
+func readTime(r io.Reader) (time.Time, error) {
+	var hdr struct {
+		Sec   int64
+		Nsec  uint32
+		Flags uint8
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return time.Time{}, err
+	}
+
+	var offset int32
+	if hdr.Flags&timeFlagOffset != 0 {
+		if err := binary.Read(r, binary.LittleEndian, &offset); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if hdr.Nsec >= 1e9 {
+		return time.Time{}, InvalidTime // The unit was read completely, so the stream is still usable.
+	}
+
+	t := time.Unix(hdr.Sec, int64(hdr.Nsec))
+	if hdr.Flags&timeFlagOffset == 0 {
+		return t.UTC(), nil
+	}
+	return t.In(time.FixedZone("", int(offset))), nil
+}
+
 // IdKVPair will be returned from ReadIdKVPair. ValueType and ValuePayload are the first two outputs of ReadUnit for the value.
 type IdKVPair struct {
 	Key          uint8
@@ -137,7 +340,7 @@ type TextKVPair struct {
 	ValuePayload interface{}
 }
 
-// ReadTextKVPair reads a Bin(as string) + any unit pair. err will be Terminated, if this was the last KVPair.
+// ReadTextKVPair reads a Bin or String (as string) + any unit pair. err will be Terminated, if this was the last KVPair.
 func ReadTextKVPair(ur UnitReader) (kvp TextKVPair, err error) {
 	var ut UnitType
 	var data interface{}
@@ -149,6 +352,8 @@ func ReadTextKVPair(ur UnitReader) (kvp TextKVPair, err error) {
 	switch ut {
 	case UTBin:
 		kvp.Key = string(data.([]byte))
+	case UTString:
+		kvp.Key = data.(string)
 	case UTTerm:
 		err = Terminated
 		return
@@ -161,6 +366,27 @@ func ReadTextKVPair(ur UnitReader) (kvp TextKVPair, err error) {
 	return
 }
 
+// unitConsumed tells, if the unit ut was read completely, although ReadUnit returned the error err.
+// In that case the stream is still usable and the unit can be treated as skipped.
+func unitConsumed(ut UnitType, err error) bool {
+	switch err {
+	case InvalidUTF8:
+		return ut == UTString
+	case InvalidTime:
+		return ut == UTTime
+	case InvalidBigInt:
+		return ut == UTBigInt
+	case InvalidStrRef:
+		return ut == UTBin
+	case InvalidSized:
+		return ut == UTSized
+	case UnknownUnit:
+		return isExt(ut)
+	}
+	_, ok := err.(*ExtDecodeError)
+	return ok && isExt(ut)
+}
+
 const maxSkipDepth = 16
 
 func skipUnit(ur UnitReader, ut UnitType, data interface{}, revDepth int) error {
@@ -168,13 +394,24 @@ func skipUnit(ur UnitReader, ut UnitType, data interface{}, revDepth int) error
 		return TooDeeplyNested
 	}
 
+	if isExt(ut) {
+		return nil // Already read completely by ReadUnit
+	}
+	if sc, ok := data.(*SizedContainer); ok && sc.sur == simpleReader(ur) {
+		return sc.sur.skipSized(sc) // No need to parse the content, the nesting depth doesn't matter
+	}
+
 	switch ut {
-	case UTNil, UTRequest, UTAnswer, UTEvent, UTBin, UTNumber, UTUKey, UTTerm, UTBool, UTByte:
+	case UTNil, UTRequest, UTAnswer, UTEvent, UTBin, UTString, UTNumber, UTUKey, UTTerm, UTBool, UTByte, UTFloat, UTTime, UTDuration,
+		UTUint64, UTBigInt:
 		return nil
 	case UTList:
 		for {
 			nUt, nData, nErr := ur.ReadUnit()
 			if nErr != nil {
+				if unitConsumed(nUt, nErr) {
+					continue
+				}
 				return nErr
 			}
 
@@ -188,33 +425,35 @@ func skipUnit(ur UnitReader, ut UnitType, data interface{}, revDepth int) error
 		}
 	case UTTextKVMap:
 		for {
-			switch kvp, err := ReadTextKVPair(ur); err {
-			case nil:
+			switch kvp, err := ReadTextKVPair(ur); {
+			case err == nil:
 				if err := skipUnit(ur, kvp.ValueType, kvp.ValuePayload, revDepth-1); err != nil {
 					return err
 				}
-			case Terminated:
+			case err == Terminated:
 				return nil
-			default:
+			case !unitConsumed(kvp.ValueType, err):
 				return err
 			}
 		}
 	case UTIdKVMap:
 		for {
-			switch kvp, err := ReadIdKVPair(ur); err {
-			case nil:
+			switch kvp, err := ReadIdKVPair(ur); {
+			case err == nil:
 				if err := skipUnit(ur, kvp.ValueType, kvp.ValuePayload, revDepth-1); err != nil {
 					return err
 				}
-			case Terminated:
+			case err == Terminated:
 				return nil
-			default:
+			case !unitConsumed(kvp.ValueType, err):
 				return err
 			}
 		}
 	case UTBinStream:
 		bsr := data.(*BinstreamReader)
 		return bsr.FastForward()
+	case UTArray:
+		return data.(*ArrayReader).Close()
 	}
 
 	return UnexpectedUnit
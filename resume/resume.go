@@ -0,0 +1,256 @@
+// Package resume implements resumable file transfers on top of binproto BinStreams.
+//
+// A receiver keeps the incomplete file in a ".part" file next to the destination, its size is the progress of the transfer.
+// After a connection broke, the receiver sends a resume request with that progress (RequestResume), the sender answers with
+// the rest of the file (ReadResumeRequest + SendFile) and the receiver appends it to the part file (ReceiveFile).
+// When the file is complete, its SHA-256 digest is verified and the part file is renamed to the destination.
+//
+// The functions only send and read the payload unit (an IdKVMap), the Request / Answer around it is up to the caller.
+//
+// Resume request:
+//
+//	1 - Bin       - transfer ID
+//	2 - Number    - offset
+//
+// Transfer:
+//
+//	1 - Bin       - transfer ID
+//	2 - Number    - offset
+//	3 - Number    - total size of the file
+//	4 - BinStream - file content from offset on
+//	5 - Bin       - SHA-256 digest of the whole file
+package resume
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"github.com/silvasur/binproto"
+	"io"
+	"os"
+)
+
+// Errors
+var (
+	OffsetMismatch = errors.New("Transfer does not continue at the stored progress")
+	WrongTransfer  = errors.New("Received data belongs to a different transfer")
+	IntegrityError = errors.New("Transferred file is corrupted")
+)
+
+// PartPath returns the path of the incomplete file for the destination path.
+func PartPath(path string) string {
+	return path + ".part"
+}
+
+// Progress returns how many bytes of the file at path were already received.
+func Progress(path string) (int64, error) {
+	fi, err := os.Stat(PartPath(path))
+	switch {
+	case err == nil:
+		return fi.Size(), nil
+	case os.IsNotExist(err):
+		return 0, nil
+	}
+	return 0, err
+}
+
+// RequestResume sends a resume request for the transfer id into path. The offset of the request is returned.
+func RequestResume(w io.Writer, id string, path string) (int64, error) {
+	offset, err := Progress(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := binproto.InitIdKVMap(w); err != nil {
+		return 0, err
+	}
+	if err := binproto.SendUKey(w, 1); err != nil {
+		return 0, err
+	}
+	if err := binproto.SendBin(w, []byte(id)); err != nil {
+		return 0, err
+	}
+	if err := binproto.SendUKey(w, 2); err != nil {
+		return 0, err
+	}
+	if err := binproto.SendNumber(w, offset); err != nil {
+		return 0, err
+	}
+	return offset, binproto.SendTerm(w)
+}
+
+// ReadResumeRequest reads a resume request sent by RequestResume.
+func ReadResumeRequest(ur binproto.UnitReader) (id string, offset int64, err error) {
+	if _, err = binproto.ReadExpect(ur, binproto.UTIdKVMap); err != nil {
+		return
+	}
+
+	var bid []byte
+	err = binproto.ScanIdKVMap(ur, map[byte]binproto.UKeyGetter{
+		1: {Type: binproto.UTBin, Action: binproto.ActionStoreBin(&bid)},
+		2: {Type: binproto.UTNumber, Action: binproto.ActionStoreNumber(&offset)}}, false)
+	id = string(bid)
+	return
+}
+
+// SendFile sends the file at path from offset on as the transfer id.
+func SendFile(w io.Writer, id string, path string, offset int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if offset < 0 || offset > size {
+		return OffsetMismatch
+	}
+
+	// The digest covers the whole file, so we also need to hash the part the receiver already has.
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		return err
+	}
+
+	if err := binproto.InitIdKVMap(w); err != nil {
+		return err
+	}
+	if err := binproto.SendUKey(w, 1); err != nil {
+		return err
+	}
+	if err := binproto.SendBin(w, []byte(id)); err != nil {
+		return err
+	}
+	if err := binproto.SendUKey(w, 2); err != nil {
+		return err
+	}
+	if err := binproto.SendNumber(w, offset); err != nil {
+		return err
+	}
+	if err := binproto.SendUKey(w, 3); err != nil {
+		return err
+	}
+	if err := binproto.SendNumber(w, size); err != nil {
+		return err
+	}
+	if err := binproto.SendUKey(w, 4); err != nil {
+		return err
+	}
+
+	bsw, err := binproto.InitBinStreamWithOptions(w, binproto.BinstreamOptions{
+		Size:     size - offset,
+		HasSize:  true,
+		Checksum: binproto.ChecksumCRC32})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bsw, io.TeeReader(f, h)); err != nil {
+		if aerr := bsw.Abort(err); aerr != nil {
+			return aerr
+		}
+		return err
+	}
+	if err := bsw.Close(); err != nil {
+		return err
+	}
+
+	if err := binproto.SendUKey(w, 5); err != nil {
+		return err
+	}
+	if err := binproto.SendBin(w, h.Sum(nil)); err != nil {
+		return err
+	}
+	return binproto.SendTerm(w)
+}
+
+// ReceiveFile receives the transfer id sent by SendFile into path.
+//
+// The data is appended to the part file. If the transfer is interrupted, the received data stays there,
+// so the transfer can be resumed later. If the complete file does not match the digest, the part file is
+// removed and IntegrityError is returned.
+func ReceiveFile(ur binproto.UnitReader, id string, path string) error {
+	progress, err := Progress(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := binproto.ReadExpect(ur, binproto.UTIdKVMap); err != nil {
+		return err
+	}
+
+	var offset, size int64
+	var digest []byte
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	err = binproto.ScanIdKVMap(ur, map[byte]binproto.UKeyGetter{
+		1: {Type: binproto.UTBin, Action: func(data interface{}, ur binproto.UnitReader) (error, bool) {
+			if string(data.([]byte)) != id {
+				return WrongTransfer, false
+			}
+			return nil, false
+		}},
+		2: {Type: binproto.UTNumber, Action: func(data interface{}, ur binproto.UnitReader) (error, bool) {
+			if offset = data.(int64); offset != progress {
+				return OffsetMismatch, false
+			}
+			return nil, false
+		}},
+		3: {Type: binproto.UTNumber, Action: binproto.ActionStoreNumber(&size)},
+		4: {Type: binproto.UTBinStream, Action: func(data interface{}, ur binproto.UnitReader) (error, bool) {
+			var err error
+			if f, err = os.OpenFile(PartPath(path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+				if ffErr := data.(*binproto.BinstreamReader).FastForward(); ffErr != nil {
+					return ffErr, true
+				}
+				return err, false
+			}
+			if err, fatal := binproto.ActionCopyBinStream(f)(data, ur); err != nil {
+				return err, fatal
+			}
+			return f.Sync(), false
+		}},
+		5: {Type: binproto.UTBin, Action: binproto.ActionStoreBin(&digest)}}, false)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	f = nil
+
+	if err := verify(PartPath(path), size, digest); err != nil {
+		os.Remove(PartPath(path))
+		return err
+	}
+
+	return os.Rename(PartPath(path), path)
+}
+
+func verify(path string, size int64, digest []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+
+	if n != size || !bytes.Equal(h.Sum(nil), digest) {
+		return IntegrityError
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package resume
+
+import (
+	"bytes"
+	"github.com/silvasur/binproto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumedTransfer(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	content := bytes.Repeat([]byte("some file content "), 10000)
+	if err := ioutil.WriteFile(src, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// An earlier transfer was interrupted after 1000 bytes.
+	if err := ioutil.WriteFile(PartPath(dst), content[:1000], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	toServer := new(bytes.Buffer)
+	offset, err := RequestResume(toServer, "file-1", dst)
+	if err != nil {
+		t.Fatalf("RequestResume failed: %s", err)
+	}
+	if offset != 1000 {
+		t.Errorf("Wrong offset: %d", offset)
+	}
+
+	id, offset, err := ReadResumeRequest(binproto.NewSimpleUnitReader(toServer))
+	if err != nil {
+		t.Fatalf("ReadResumeRequest failed: %s", err)
+	}
+	if id != "file-1" || offset != 1000 {
+		t.Errorf("Wrong resume request: %s, %d", id, offset)
+	}
+
+	toClient := new(bytes.Buffer)
+	if err := SendFile(toClient, id, src, offset); err != nil {
+		t.Fatalf("SendFile failed: %s", err)
+	}
+
+	if err := ReceiveFile(binproto.NewSimpleUnitReader(toClient), "file-1", dst); err != nil {
+		t.Fatalf("ReceiveFile failed: %s", err)
+	}
+
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Wrong file content, got %d bytes", len(got))
+	}
+	if _, err := os.Stat(PartPath(dst)); !os.IsNotExist(err) {
+		t.Errorf("Part file was not removed: %v", err)
+	}
+}
+
+func TestCorruptedTransfer(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	content := bytes.Repeat([]byte("some file content "), 100)
+	if err := ioutil.WriteFile(src, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(PartPath(dst), bytes.Repeat([]byte{'x'}, 100), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := SendFile(buf, "file-1", src, 100); err != nil {
+		t.Fatalf("SendFile failed: %s", err)
+	}
+
+	if err := ReceiveFile(binproto.NewSimpleUnitReader(buf), "file-1", dst); err != IntegrityError {
+		t.Errorf("Expected IntegrityError, got: %v", err)
+	}
+	if _, err := os.Stat(PartPath(dst)); !os.IsNotExist(err) {
+		t.Errorf("Corrupted part file was not removed: %v", err)
+	}
+}
@@ -3,6 +3,9 @@ package binproto
 import (
 	"encoding/binary"
 	"io"
+	"math/big"
+	"time"
+	"unicode/utf8"
 )
 
 func SendNil(w io.Writer) error {
@@ -11,6 +14,10 @@ func SendNil(w io.Writer) error {
 }
 
 func sendRAE(w io.Writer, what UnitType, code uint16) error {
+	if useCompact(w) {
+		return writeTypedUvarint(w, what-UTRequest+UTCompactRequest, uint64(code))
+	}
+
 	if _, err := w.Write([]byte{byte(what)}); err != nil {
 		return err
 	}
@@ -23,6 +30,14 @@ func InitAnswer(w io.Writer, code uint16) error  { return sendRAE(w, UTAnswer, c
 func InitEvent(w io.Writer, code uint16) error   { return sendRAE(w, UTEvent, code) }
 
 func SendBin(w io.Writer, bindata []byte) error {
+	if useCompact(w) {
+		if err := writeTypedUvarint(w, UTCompactBin, uint64(len(bindata))); err != nil {
+			return err
+		}
+		_, err := w.Write(bindata)
+		return err
+	}
+
 	if _, err := w.Write([]byte{UTBin}); err != nil {
 		return err
 	}
@@ -35,7 +50,51 @@ func SendBin(w io.Writer, bindata []byte) error {
 	return err
 }
 
+func SendUint64(w io.Writer, n uint64) error {
+	if _, err := w.Write([]byte{UTUint64}); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, n)
+}
+
+// SendBigInt sends a BigInt unit: a sign byte (1 for negative numbers), the length and the big-endian magnitude.
+func SendBigInt(w io.Writer, n *big.Int) error {
+	mag := n.Bytes()
+	buf := make([]byte, 6, 6+len(mag))
+	buf[0] = UTBigInt
+	if n.Sign() < 0 {
+		buf[1] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[2:], uint32(len(mag)))
+
+	_, err := w.Write(append(buf, mag...))
+	return err
+}
+
+// SendString sends a String unit. If s is not valid UTF-8, InvalidUTF8 is returned and nothing is sent.
+func SendString(w io.Writer, s string) error {
+	if !utf8.ValidString(s) {
+		return InvalidUTF8
+	}
+
+	if _, err := w.Write([]byte{UTString}); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, s)
+	return err
+}
+
 func SendNumber(w io.Writer, n int64) error {
+	if useCompact(w) {
+		return writeTypedVarint(w, UTCompactNumber, n)
+	}
+
 	if _, err := w.Write([]byte{UTNumber}); err != nil {
 		return err
 	}
@@ -43,6 +102,42 @@ func SendNumber(w io.Writer, n int64) error {
 	return binary.Write(w, binary.LittleEndian, n)
 }
 
+func SendFloat(w io.Writer, f float64) error {
+	if _, err := w.Write([]byte{UTFloat}); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, f)
+}
+
+const timeFlagOffset = 1
+
+// SendTime sends a Time unit. The zone offset of t is sent too, unless t is in UTC. The name of the zone is lost.
+func SendTime(w io.Writer, t time.Time) error {
+	buf := make([]byte, 18)
+	buf[0] = UTTime
+	binary.LittleEndian.PutUint64(buf[1:], uint64(t.Unix()))
+	binary.LittleEndian.PutUint32(buf[9:], uint32(t.Nanosecond()))
+	if t.Location() == time.UTC {
+		buf = buf[:14]
+	} else {
+		_, offset := t.Zone()
+		buf[13] = timeFlagOffset
+		binary.LittleEndian.PutUint32(buf[14:], uint32(int32(offset)))
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func SendDuration(w io.Writer, d time.Duration) error {
+	if _, err := w.Write([]byte{UTDuration}); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, int64(d))
+}
+
 func InitList(w io.Writer) error {
 	_, err := w.Write([]byte{UTList})
 	return err
@@ -78,7 +173,11 @@ func SendByte(w io.Writer, b byte) error {
 	return sendTypedByte(w, UTByte, b)
 }
 
+// SendTextKey sends a key of a TextKVMap. If w is a StringTabler (see NewStringTableWriter), the string table is used.
 func SendTextKey(w io.Writer, key string) error {
+	if st := stringTable(w); st != nil {
+		return st.sendKey(w, key)
+	}
 	return SendBin(w, []byte(key))
 }
 
@@ -88,10 +187,23 @@ func SendTerm(w io.Writer) error {
 }
 
 func InitBinStream(w io.Writer) (*BinstreamWriter, error) {
-	_, err := w.Write([]byte{UTBinStream})
+	return InitBinStreamWithOptions(w, BinstreamOptions{})
+}
+
+// InitBinStreamWithOptions is like InitBinStream, but allows to use the BinStream extensions configured in opts.
+func InitBinStreamWithOptions(w io.Writer, opts BinstreamOptions) (*BinstreamWriter, error) {
+	bsw, err := newBinstreamWriter(w, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &BinstreamWriter{w: w}, nil
+	if _, err := w.Write([]byte{UTBinStream}); err != nil {
+		return nil, err
+	}
+
+	if err := bsw.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	return bsw, nil
 }
@@ -0,0 +1,125 @@
+package binproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Errors of Sized units.
+var (
+	InvalidSized = errors.New("Invalid Sized unit")
+)
+
+// offsetReader counts the bytes read, so a SimpleUnitReader knows its position in the stream.
+type offsetReader struct {
+	r   io.Reader
+	off int64
+}
+
+func (or *offsetReader) Read(p []byte) (int, error) {
+	n, err := or.r.Read(p)
+	or.off += int64(n)
+	return n, err
+}
+
+// SizedContainer is the payload of a List, TextKVMap or IdKVMap that was sent in a Sized unit.
+// SkipUnit can skip such a container without parsing it, if it is called with the SimpleUnitReader that read the container
+// or with a UnitReader that embeds it (and reads from it directly). Other UnitReaders (e.g. the ones of Demux) might have
+// read ahead, so they have to parse the container and are subject to the nesting limit of SkipUnit.
+type SizedContainer struct {
+	Size int64 // Length of the container in bytes, including the type byte and the Term
+	sur  *SimpleUnitReader
+	end  int64 // Offset in sur, where the container ends
+}
+
+// readSized reads the header of a Sized unit and the type byte of the container.
+func (sur *SimpleUnitReader) readSized() (UnitType, interface{}, error) {
+	var l uint32
+	if err := binary.Read(sur.r, binary.LittleEndian, &l); err != nil {
+		return UTSized, nil, err
+	}
+	start := sur.r.off
+	if l == 0 {
+		return UTSized, nil, InvalidSized
+	}
+
+	var ut [1]byte
+	if _, err := io.ReadFull(sur.r, ut[:]); err != nil {
+		return UTSized, nil, err
+	}
+	if !isContainer(UnitType(ut[0])) {
+		// Skip the content, so the stream is still usable.
+		if _, err := io.CopyN(ioutil.Discard, sur.r, int64(l)-1); err != nil {
+			return UTSized, nil, err
+		}
+		return UTSized, nil, InvalidSized
+	}
+
+	return UnitType(ut[0]), &SizedContainer{Size: int64(l), sur: sur, end: start + int64(l)}, nil
+}
+
+// skipSized skips to the end of a sized container.
+func (sur *SimpleUnitReader) skipSized(sc *SizedContainer) error {
+	sur.mu.Lock()
+	defer sur.mu.Unlock()
+
+	if sur.open != nil {
+		select {
+		case <-sur.open.done:
+			sur.err = sur.open.broken
+			sur.open = nil
+		default:
+			return ErrStreamOpen
+		}
+	}
+	if sur.err != nil {
+		return sur.err
+	}
+
+	n := sc.end - sur.r.off
+	if n < 0 {
+		return InvalidSized // We already read past the end, the length was wrong.
+	}
+	_, err := io.CopyN(ioutil.Discard, sur.r, n)
+	return err
+}
+
+// SizedWriter buffers a container and sends it as a Sized unit, see BeginSized.
+type SizedWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// BeginSized starts a Sized unit. Write exactly one List, TextKVMap or IdKVMap (including its Term) to the SizedWriter,
+// then call Close to send it. Receivers can then skip the container without parsing it.
+//
+// Since a skipped container would not update the receiver's string table, the SizedWriter does not use the string table
+// of w, and StrDef and StrReset units must not be written to it.
+func BeginSized(w io.Writer) *SizedWriter {
+	return &SizedWriter{w: w}
+}
+
+// Write implements io.Writer.
+func (sw *SizedWriter) Write(p []byte) (int, error) { return sw.buf.Write(p) }
+
+// CompactEncoding implements CompactEncoder, the encoding of the underlying writer is used.
+func (sw *SizedWriter) CompactEncoding() bool { return useCompact(sw.w) }
+
+// Close sends the buffered container.
+func (sw *SizedWriter) Close() error {
+	if sw.buf.Len() == 0 || sw.buf.Len() > math.MaxUint32 {
+		return InvalidSized
+	}
+
+	hdr := []byte{UTSized, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(hdr[1:], uint32(sw.buf.Len()))
+	if _, err := sw.w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := sw.buf.WriteTo(sw.w)
+	return err
+}
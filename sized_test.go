@@ -0,0 +1,133 @@
+package binproto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// wrappedReader hides the SimpleUnitReader, so SkipUnit has to parse sized containers.
+type wrappedReader struct{ ur UnitReader }
+
+func (wr wrappedReader) ReadUnit() (UnitType, interface{}, error) { return wr.ur.ReadUnit() }
+
+// embeddingReader wraps a SimpleUnitReader by embedding, like most UnitReader implementations do.
+type embeddingReader struct {
+	*SimpleUnitReader
+	units int
+}
+
+func (er *embeddingReader) ReadUnit() (UnitType, interface{}, error) {
+	er.units++
+	return er.SimpleUnitReader.ReadUnit()
+}
+
+func nestedLists(b *Builder, depth int) {
+	if depth == 0 {
+		b.Number(int64(depth))
+		return
+	}
+	b.List(func(l *Builder) { nestedLists(l, depth-1) })
+}
+
+func TestSized(t *testing.T) {
+	w := new(bytes.Buffer)
+	vw := NewValidatingWriter(w)
+	b := NewBuilder(vw).
+		Sized(func(s *Builder) { nestedLists(s, 40) }).
+		IdKVMap(func(m *IdKVMapBuilder) {
+			m.Sized(1, func(s *Builder) {
+				s.TextKVMap(func(m *TextKVMapBuilder) { m.String("foo", "bar") })
+			})
+		}).
+		Number(42)
+	chkerr(t, b.Err(), "Builder")
+	chkerr(t, vw.CheckComplete(), "CheckComplete")
+
+	// Skip with the SimpleUnitReader: The nesting depth doesn't matter.
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	ut, data, err := ur.ReadUnit()
+	if err != nil || ut != UTList {
+		t.Fatalf("Expected a List, got: %s, %v", ut, err)
+	}
+	if sc, ok := data.(*SizedContainer); !ok || sc.Size != 40*2+9 {
+		t.Fatalf("Expected a *SizedContainer with size 89, got: %v", data)
+	}
+	chkerr(t, SkipUnit(ur, ut, data), "SkipUnit")
+
+	// Read normally
+	readExpect2(t, ur, UTIdKVMap)
+	if kvp, err := ReadIdKVPair(ur); err != nil || kvp.Key != 1 || kvp.ValueType != UTTextKVMap {
+		t.Fatalf("Unexpected pair: %v, %v", kvp, err)
+	}
+	if kvp, err := ReadTextKVPair(ur); err != nil || kvp.Key != "foo" || kvp.ValuePayload.(string) != "bar" {
+		t.Fatalf("Unexpected pair: %v, %v", kvp, err)
+	}
+	readExpect2(t, ur, UTTerm)
+	readExpect2(t, ur, UTTerm)
+	if n := readExpect2(t, ur, UTNumber).(int64); n != 42 {
+		t.Errorf("Wrong number: %d", n)
+	}
+
+	// A wrapping UnitReader can skip without parsing, too.
+	er := &embeddingReader{SimpleUnitReader: NewSimpleUnitReader(bytes.NewReader(w.Bytes()))}
+	chkerr(t, SkipNext(er), "SkipNext")
+	readExpect2(t, er, UTIdKVMap)
+	if er.units != 2 {
+		t.Errorf("Expected 2 units read through the wrapper, got %d", er.units)
+	}
+
+	// Other UnitReaders have to parse the container.
+	wr := wrappedReader{NewSimpleUnitReader(bytes.NewReader(w.Bytes()))}
+	if err := SkipNext(wr); err != TooDeeplyNested {
+		t.Errorf("Expected TooDeeplyNested, got: %v", err)
+	}
+	wr = wrappedReader{NewSimpleUnitReader(bytes.NewReader(w.Bytes()[5+89:]))}
+	chkerr(t, SkipNext(wr), "SkipNext")
+	if n := readExpect2(t, wr, UTNumber).(int64); n != 42 {
+		t.Errorf("Wrong number: %d", n)
+	}
+}
+
+func TestSizedValidation(t *testing.T) {
+	for _, raw := range [][]byte{
+		{0x1a, 0x03, 0x00, 0x00, 0x00, 0x06, 0x0b},                   // Too long
+		{0x1a, 0x01, 0x00, 0x00, 0x00, 0x06, 0x0b},                   // Too short
+		{0x1a, 0x01, 0x00, 0x00, 0x00, 0x00},                         // Not a container
+		{0x1a, 0x05, 0x00, 0x00, 0x00, 0x06, 0x1b, 0x01, 0x61, 0x0b}, // StrDef inside
+		{0x1a, 0x03, 0x00, 0x00, 0x00, 0x06, 0x1d, 0x0b},             // StrReset inside
+		{0x1a, 0x03, 0x00, 0x00, 0x00, 0x1d, 0x06, 0x0b},             // StrReset before the container
+	} {
+		vw := NewValidatingWriter(ioutil.Discard)
+		if _, err := vw.Write(raw); err == nil {
+			if err := vw.CheckComplete(); err == nil {
+				t.Errorf("Invalid Sized unit %v accepted", raw)
+			}
+		}
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader([]byte{
+		0x1a, 0x01, 0x00, 0x00, 0x00, 0x00, // Sized(Nil)
+		0x00})) // Nil
+	if _, _, err := ur.ReadUnit(); err != InvalidSized {
+		t.Errorf("Expected InvalidSized, got: %v", err)
+	}
+	readExpect2(t, ur, UTNil)
+
+	raw := []byte{
+		0x06,                               // List
+		0x1a, 0x01, 0x00, 0x00, 0x00, 0x00, // Sized(Nil)
+		0x0b, // Term
+		0x00} // Nil
+	ur = NewSimpleUnitReader(bytes.NewReader(raw))
+	chkerr(t, SkipNext(ur), "SkipNext")
+	readExpect2(t, ur, UTNil)
+
+	other := NewDemux(NewSimpleUnitReader(bytes.NewReader(raw))).Other()
+	readExpect2(t, other, UTList)
+	if _, _, err := other.ReadUnit(); err != InvalidSized {
+		t.Errorf("Expected InvalidSized through Demux, got: %v", err)
+	}
+	readExpect2(t, other, UTTerm)
+	readExpect2(t, other, UTNil)
+}
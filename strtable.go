@@ -0,0 +1,122 @@
+package binproto
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// MaxStringTable is the maximum number of entries of a string table.
+const MaxStringTable = 1 << 16
+
+// Errors of string tables.
+var (
+	InvalidStrRef   = errors.New("Reference to an undefined string table entry")
+	StringTableFull = errors.New("Too many string table entries defined")
+)
+
+// StringTabler is implemented by writers that deduplicate TextKVMap keys with a string table, see NewStringTableWriter.
+//
+// Wrappers that pass all data through in order (like ValidatingWriter) should delegate it. Wrappers that buffer or reorder
+// data must not, otherwise the peer might see a reference before the definition (Message and SizedWriter don't delegate it).
+type StringTabler interface {
+	StringTable() *StringTable
+}
+
+func stringTable(w io.Writer) *StringTable {
+	if st, ok := w.(StringTabler); ok {
+		return st.StringTable()
+	}
+	return nil
+}
+
+// StringTable maps strings to their index in the table of the peer.
+type StringTable struct {
+	index map[string]int
+}
+
+// sendKey sends a reference, if key is already in the table. Otherwise the key is defined.
+// If the table is full, the key is sent as an ordinary Bin.
+func (st *StringTable) sendKey(w io.Writer, key string) error {
+	if i, ok := st.index[key]; ok {
+		return writeTypedUvarint(w, UTStrRef, uint64(i))
+	}
+	if len(st.index) >= MaxStringTable || uint64(len(key)) > math.MaxUint32 {
+		return SendBin(w, []byte(key))
+	}
+
+	if err := writeTypedUvarint(w, UTStrDef, uint64(len(key))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, key); err != nil {
+		return err
+	}
+	st.index[key] = len(st.index)
+	return nil
+}
+
+// StringTableWriter wraps a writer and sends TextKVMap keys (see SendTextKey) using a string table:
+// The first occurrence of a key defines an entry, later occurrences only send a reference to it.
+// Readers resolve the references transparently, they return the keys as Bins.
+//
+// The table is valid for the whole connection, unless Reset is called (e.g. at the start of every message).
+type StringTableWriter struct {
+	w  io.Writer
+	st StringTable
+}
+
+// NewStringTableWriter creates a StringTableWriter that writes to w.
+func NewStringTableWriter(w io.Writer) *StringTableWriter {
+	return &StringTableWriter{w: w, st: StringTable{index: make(map[string]int)}}
+}
+
+// Write implements io.Writer.
+func (stw *StringTableWriter) Write(p []byte) (int, error) { return stw.w.Write(p) }
+
+// Flush flushes the underlying writer, see FlushMessage.
+func (stw *StringTableWriter) Flush() error { return FlushMessage(stw.w) }
+
+// CompactEncoding implements CompactEncoder, the encoding of the underlying writer is used.
+func (stw *StringTableWriter) CompactEncoding() bool { return useCompact(stw.w) }
+
+// StringTable implements StringTabler.
+func (stw *StringTableWriter) StringTable() *StringTable { return &stw.st }
+
+// Reset clears the table and tells the peer to do the same.
+func (stw *StringTableWriter) Reset() error {
+	if _, err := stw.w.Write([]byte{UTStrReset}); err != nil {
+		return err
+	}
+	stw.st.index = make(map[string]int)
+	return nil
+}
+
+// readStrDef reads the payload of a StrDef unit and adds it to the table of the reader.
+func (sur *SimpleUnitReader) readStrDef() ([]byte, error) {
+	l, err := readUvarint(sur.r, math.MaxUint32)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(sur.r, buf); err != nil {
+		return nil, err
+	}
+
+	if len(sur.strings) >= MaxStringTable {
+		return nil, StringTableFull
+	}
+	sur.strings = append(sur.strings, buf)
+	return append([]byte(nil), buf...), nil // The caller might modify the data
+}
+
+// readStrRef reads a StrRef unit and resolves the reference.
+func (sur *SimpleUnitReader) readStrRef() ([]byte, error) {
+	i, err := readUvarint(sur.r, math.MaxUint32)
+	if err != nil {
+		return nil, err
+	}
+	if i >= uint64(len(sur.strings)) {
+		return nil, InvalidStrRef
+	}
+	return append([]byte(nil), sur.strings[i]...), nil
+}
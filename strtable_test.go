@@ -0,0 +1,85 @@
+package binproto
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func sendRecords(w io.Writer, n int) error {
+	return NewBuilder(w).List(func(l *Builder) {
+		for i := 0; i < n; i++ {
+			l.TextKVMap(func(m *TextKVMapBuilder) {
+				m.Number("timestamp", int64(i)).Number("temperature", 20).Bool("valid", true)
+			})
+		}
+	}).Err()
+}
+
+func TestStringTable(t *testing.T) {
+	plain := new(bytes.Buffer)
+	chkerr(t, sendRecords(plain, 1000), "sendRecords")
+
+	w := new(bytes.Buffer)
+	stw := NewStringTableWriter(w)
+	vw := NewValidatingWriter(stw)
+	chkerr(t, sendRecords(vw, 1000), "sendRecords")
+	chkerr(t, stw.Reset(), "Reset")
+	chkerr(t, sendRecords(vw, 1), "sendRecords")
+	chkerr(t, vw.CheckComplete(), "CheckComplete")
+
+	if w.Len() >= plain.Len()*2/3 {
+		t.Errorf("String table did not save enough: %d bytes, %d without table", w.Len(), plain.Len())
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	for _, n := range []int{1000, 1} {
+		readExpect2(t, ur, UTList)
+		for i := 0; i < n; i++ {
+			readExpect2(t, ur, UTTextKVMap)
+			for _, key := range []string{"timestamp", "temperature", "valid"} {
+				kvp, err := ReadTextKVPair(ur)
+				if err != nil {
+					t.Fatalf("Could not read pair: %s", err)
+				}
+				if kvp.Key != key {
+					t.Fatalf("Wrong key: got %s, want %s", kvp.Key, key)
+				}
+			}
+			readExpect2(t, ur, UTTerm)
+		}
+		readExpect2(t, ur, UTTerm)
+	}
+
+	// Messages are buffered and might be reordered, so they must not use the table.
+	w.Reset()
+	m := NewMessageWriter(NewStringTableWriter(w)).Begin()
+	chkerr(t, SendTextKey(m, "foo"), "SendTextKey")
+	chkerr(t, m.Close(), "Close")
+	if w.Bytes()[0] != UTBin {
+		t.Errorf("Message used the string table: %v", w.Bytes())
+	}
+}
+
+func TestInvalidStrRef(t *testing.T) {
+	raw := []byte{
+		0x1b, 0x03, 'f', 'o', 'o', // StrDef(foo)
+		0x1d,       // StrReset
+		0x1c, 0x00, // StrRef(0)
+		0x00} // Nil
+
+	vw := NewValidatingWriter(ioutil.Discard)
+	if _, err := vw.Write(raw); err == nil {
+		t.Errorf("Invalid reference accepted")
+	}
+
+	ur := NewSimpleUnitReader(bytes.NewReader(raw))
+	if b := readExpect2(t, ur, UTBin).([]byte); string(b) != "foo" {
+		t.Errorf("Wrong data: %q", b)
+	}
+	if _, _, err := ur.ReadUnit(); err != InvalidStrRef {
+		t.Errorf("Expected InvalidStrRef, got: %v", err)
+	}
+	readExpect2(t, ur, UTNil)
+}
@@ -0,0 +1,393 @@
+package binproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ValidationError is returned by ValidatingWriter, if an illegal unit was written.
+type ValidationError struct {
+	Unit   UnitType
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Illegal %s (%d): %s", e.Unit, byte(e.Unit), e.Reason)
+}
+
+// States of ValidatingWriter
+const (
+	vsUnit   = iota // Expecting a unit type byte
+	vsHeader        // Collecting the fixed size header of a payload
+	vsSkip          // Passing through payload data
+	vsVarint        // Collecting a varint header
+)
+
+// Kinds of headers
+const (
+	vhBinLen    = iota // uint32 length of Bin data
+	vhChunk            // int32 length of a BinStream chunk
+	vhVarint           // Varint without following data
+	vhVarintLen        // Varint length of CompactBin data
+	vhTime             // Fixed part of a Time unit
+	vhArray            // Kind and count of an Array
+	vhBigInt           // Sign and length of a BigInt
+	vhSized            // Length of a Sized unit
+	vhStrRef           // Varint index of a StrRef
+)
+
+type vContainer struct {
+	ut       UnitType
+	wantKey  bool   // For KVMaps: The next unit must be a key
+	sizedEnd uint64 // Position after the container, if it is in a Sized unit. 0 otherwise.
+}
+
+// ValidatingWriter checks the structure of the written units and rejects illegal sequences, before they are sent.
+// It detects e.g. UKeys outside of IdKVMaps, superfluous Terms or TextKVMap keys that are not Bins or Strings.
+//
+// ValidatingWriter parses the written data, so it can be used with all Send* functions. It does not buffer anything,
+// so it does not check the contents of units (e.g. if a String is valid UTF-8).
+type ValidatingWriter struct {
+	w          io.Writer
+	err        error
+	containers []vContainer
+	inMessage  bool   // A Request, Answer or Event header was sent, its value did not start yet
+	sized      uint64 // Length of a Sized unit, whose container did not start yet
+	pos        uint64 // Position of the current unit type byte
+	consumed   uint64 // Bytes of previous writes
+	strings    uint64 // Number of string table entries
+	state      int
+	header     []byte
+	headerLen  int
+	headerKind int
+	skip       uint64
+	afterSkip  int // State after the payload was passed through
+}
+
+// NewValidatingWriter creates a ValidatingWriter that writes the validated data to w.
+func NewValidatingWriter(w io.Writer) *ValidatingWriter {
+	return &ValidatingWriter{w: w}
+}
+
+func (vw *ValidatingWriter) top() *vContainer {
+	if len(vw.containers) == 0 {
+		return nil
+	}
+	return &vw.containers[len(vw.containers)-1]
+}
+
+// inSized tells, if the current position is inside of a Sized unit.
+func (vw *ValidatingWriter) inSized() bool {
+	if vw.sized > 0 {
+		return true
+	}
+	for _, c := range vw.containers {
+		if c.sizedEnd != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUnit checks, if the unit ut may appear at the current position and updates the structure.
+func (vw *ValidatingWriter) checkUnit(ut UnitType) error {
+	top := vw.top()
+
+	var sizedEnd uint64
+	if vw.sized > 0 {
+		if !isContainer(ut) {
+			return &ValidationError{ut, "a Sized unit must contain a List or KVMap"}
+		}
+		sizedEnd = vw.pos + vw.sized
+		vw.sized = 0
+	}
+
+	switch ut {
+	case UTRequest, UTAnswer, UTEvent:
+		if top != nil || vw.inMessage {
+			return &ValidationError{ut, "message header inside of a value"}
+		}
+		vw.inMessage = true
+		return nil
+	case UTTerm:
+		if top == nil {
+			return &ValidationError{ut, "no open List or KVMap"}
+		}
+		if top.ut != UTList && !top.wantKey {
+			return &ValidationError{ut, fmt.Sprintf("%s terminated between key and value", top.ut)}
+		}
+		if top.sizedEnd != 0 && top.sizedEnd != vw.pos+1 {
+			return &ValidationError{ut, fmt.Sprintf("length of the Sized %s does not match", top.ut)}
+		}
+		vw.containers = vw.containers[:len(vw.containers)-1]
+		return nil
+	}
+
+	if top != nil && top.wantKey {
+		switch {
+		case top.ut == UTIdKVMap && ut != UTUKey:
+			return &ValidationError{ut, "keys of an IdKVMap must be UKeys"}
+		case top.ut == UTTextKVMap && ut != UTBin && ut != UTString:
+			return &ValidationError{ut, "keys of a TextKVMap must be Bins or Strings"}
+		}
+		top.wantKey = false
+		return nil
+	}
+
+	if ut == UTUKey {
+		return &ValidationError{ut, "only allowed as key of an IdKVMap"}
+	}
+
+	// ut is a value
+	if top != nil && top.ut != UTList {
+		top.wantKey = true
+	}
+	vw.inMessage = false
+	if isContainer(ut) {
+		vw.containers = append(vw.containers, vContainer{ut, ut != UTList, sizedEnd})
+	}
+	return nil
+}
+
+// startUnit validates a unit type byte and prepares parsing the payload.
+func (vw *ValidatingWriter) startUnit(b byte) error {
+	ut := UnitType(b)
+
+	if isExt(ut) {
+		vw.readHeader(vhBinLen, 4) // Extension units are framed like Bins, the payload is not checked.
+		return vw.checkUnit(ut)
+	}
+
+	switch ut {
+	case UTNil, UTList, UTTextKVMap, UTIdKVMap, UTTerm:
+	case UTRequest, UTAnswer, UTEvent:
+		vw.skipBytes(2, vsUnit)
+	case UTBin, UTString:
+		vw.readHeader(vhBinLen, 4)
+	case UTNumber, UTFloat, UTDuration, UTUint64:
+		vw.skipBytes(8, vsUnit)
+	case UTTime:
+		vw.readHeader(vhTime, 13)
+	case UTArray:
+		vw.readHeader(vhArray, 5)
+	case UTBigInt:
+		vw.readHeader(vhBigInt, 5)
+	case UTStrDef:
+		if vw.inSized() {
+			return &ValidationError{ut, "not allowed in a Sized unit, it might be skipped without parsing"}
+		}
+		vw.strings++
+		vw.readHeader(vhVarintLen, 0)
+		ut = UTBin
+	case UTStrRef:
+		vw.readHeader(vhStrRef, 0)
+		ut = UTBin
+	case UTStrReset:
+		if vw.inSized() {
+			return &ValidationError{ut, "not allowed in a Sized unit, it might be skipped without parsing"}
+		}
+		vw.strings = 0
+		return nil // Not a unit on its own
+	case UTSized:
+		if vw.sized > 0 {
+			return &ValidationError{ut, "a Sized unit must contain a List or KVMap"}
+		}
+		vw.readHeader(vhSized, 4)
+		return nil // The contained unit is checked
+	case UTUKey, UTBool, UTByte:
+		vw.skipBytes(1, vsUnit)
+	case UTBinStream:
+		vw.readHeader(vhChunk, 4)
+	case UTCompactRequest, UTCompactAnswer, UTCompactEvent, UTCompactNumber:
+		vw.readHeader(vhVarint, 0)
+	case UTCompactBin:
+		vw.readHeader(vhVarintLen, 0)
+	default:
+		return &ValidationError{ut, "unknown unit type"}
+	}
+
+	return vw.checkUnit(compactToStandard(ut))
+}
+
+func (vw *ValidatingWriter) skipBytes(n uint64, after int) {
+	vw.skip = n
+	vw.afterSkip = after
+	vw.state = vsSkip
+	if n == 0 {
+		vw.state = after
+	}
+}
+
+// readHeader prepares reading a header of n bytes. Varint headers have n == 0, their length is determined by the data.
+func (vw *ValidatingWriter) readHeader(kind, n int) {
+	vw.headerKind = kind
+	vw.headerLen = n
+	vw.header = vw.header[:0]
+	vw.state = vsHeader
+	if kind == vhVarint || kind == vhVarintLen || kind == vhStrRef {
+		vw.state = vsVarint
+	}
+}
+
+func (vw *ValidatingWriter) headerDone() error {
+	switch vw.headerKind {
+	case vhBinLen:
+		vw.skipBytes(uint64(binary.LittleEndian.Uint32(vw.header)), vsUnit)
+	case vhChunk:
+		l := int32(binary.LittleEndian.Uint32(vw.header))
+		if l < 0 {
+			vw.state = vsUnit
+			return nil
+		}
+		vw.skipBytes(uint64(l), vsHeader)
+		vw.headerLen = 4 // Next chunk header follows
+		vw.header = vw.header[:0]
+	case vhVarint:
+		vw.state = vsUnit
+	case vhStrRef:
+		if i, _ := binary.Uvarint(vw.header); i >= vw.strings {
+			return &ValidationError{UTStrRef, "undefined string table entry"}
+		}
+		vw.state = vsUnit
+	case vhVarintLen:
+		l, _ := binary.Uvarint(vw.header)
+		vw.skipBytes(l, vsUnit)
+	case vhTime:
+		var offsetLen uint64
+		if vw.header[12]&timeFlagOffset != 0 {
+			offsetLen = 4
+		}
+		vw.skipBytes(offsetLen, vsUnit)
+	case vhArray:
+		size := ArrayKind(vw.header[0]).size()
+		if size == 0 {
+			return &ValidationError{UTArray, "unknown array kind"}
+		}
+		vw.skipBytes(uint64(binary.LittleEndian.Uint32(vw.header[1:]))*uint64(size), vsUnit)
+	case vhBigInt:
+		if vw.header[0] > 1 {
+			return &ValidationError{UTBigInt, "invalid sign"}
+		}
+		vw.skipBytes(uint64(binary.LittleEndian.Uint32(vw.header[1:])), vsUnit)
+	case vhSized:
+		vw.sized = uint64(binary.LittleEndian.Uint32(vw.header))
+		if vw.sized == 0 {
+			return &ValidationError{UTSized, "empty"}
+		}
+		vw.state = vsUnit
+	}
+	return nil
+}
+
+// unitStart returns the index of the current unit in the data of the current write, 0 if it started in a previous write.
+// Used to not pass through the beginning of an illegal unit.
+func (vw *ValidatingWriter) unitStart() int {
+	if vw.pos < vw.consumed {
+		return 0
+	}
+	return int(vw.pos - vw.consumed)
+}
+
+// Write implements io.Writer. If the data contains an illegal unit, only the data before it is written and a *ValidationError is returned.
+// From then on, all writes fail.
+func (vw *ValidatingWriter) Write(p []byte) (int, error) {
+	if vw.err != nil {
+		return 0, vw.err
+	}
+
+	i := 0
+	for i < len(p) && vw.err == nil {
+		switch vw.state {
+		case vsUnit:
+			vw.pos = vw.consumed + uint64(i)
+			if err := vw.startUnit(p[i]); err != nil {
+				vw.err = err
+				break
+			}
+			i++
+		case vsHeader:
+			take := vw.headerLen - len(vw.header)
+			if rest := len(p) - i; take > rest {
+				take = rest
+			}
+			vw.header = append(vw.header, p[i:i+take]...)
+			i += take
+			if len(vw.header) == vw.headerLen {
+				if vw.err = vw.headerDone(); vw.err != nil {
+					i = vw.unitStart()
+				}
+			}
+		case vsSkip:
+			take := uint64(len(p) - i)
+			if take > vw.skip {
+				take = vw.skip
+			}
+			i += int(take)
+			vw.skip -= take
+			if vw.skip == 0 {
+				vw.state = vw.afterSkip
+			}
+		case vsVarint:
+			b := p[i]
+			vw.header = append(vw.header, b)
+			if len(vw.header) > binary.MaxVarintLen64 {
+				vw.err = &ValidationError{UTNil, "invalid varint"}
+				i = vw.unitStart()
+				break
+			}
+			i++
+			if b < 0x80 {
+				if vw.err = vw.headerDone(); vw.err != nil {
+					i = vw.unitStart()
+				}
+			}
+		}
+	}
+
+	if i == 0 {
+		return 0, vw.err
+	}
+	vw.consumed += uint64(i)
+
+	n, err := vw.w.Write(p[:i])
+	if err != nil {
+		vw.err = err
+		return n, err
+	}
+	return n, vw.err
+}
+
+// Flush flushes the underlying writer, see FlushMessage.
+func (vw *ValidatingWriter) Flush() error {
+	return FlushMessage(vw.w)
+}
+
+// CompactEncoding implements CompactEncoder, the encoding of the underlying writer is used.
+func (vw *ValidatingWriter) CompactEncoding() bool {
+	return useCompact(vw.w)
+}
+
+// StringTable implements StringTabler, the table of the underlying writer is used.
+func (vw *ValidatingWriter) StringTable() *StringTable {
+	return stringTable(vw.w)
+}
+
+// CheckComplete returns an error, if a unit, container or message is not complete yet.
+func (vw *ValidatingWriter) CheckComplete() error {
+	if vw.err != nil {
+		return vw.err
+	}
+
+	switch {
+	case vw.state != vsUnit:
+		return &ValidationError{UTNil, "unit incomplete"}
+	case vw.inMessage:
+		return &ValidationError{UTNil, "message without value"}
+	case vw.sized > 0:
+		return &ValidationError{UTSized, "no content"}
+	case len(vw.containers) > 0:
+		return &ValidationError{vw.top().ut, "not terminated"}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package binproto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestValidatingWriterAcceptsValid(t *testing.T) {
+	w := new(bytes.Buffer)
+	vw := NewValidatingWriter(w)
+
+	// Write the test data byte by byte, to see if units can be split over multiple writes.
+	for _, b := range data {
+		if _, err := vw.Write([]byte{b}); err != nil {
+			t.Fatalf("Valid data rejected: %s", err)
+		}
+	}
+	if err := vw.CheckComplete(); err != nil {
+		t.Errorf("CheckComplete failed: %s", err)
+	}
+	if !bytes.Equal(w.Bytes(), data) {
+		t.Errorf("Wrong data written: %v", w.Bytes())
+	}
+}
+
+func TestValidatingWriterRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w io.Writer) error
+		unit  UnitType
+	}{
+		{"UKey outside of IdKVMap", func(w io.Writer) error {
+			InitList(w)
+			return SendUKey(w, 1)
+		}, UTUKey},
+		{"extra Term", func(w io.Writer) error {
+			InitList(w)
+			SendTerm(w)
+			return SendTerm(w)
+		}, UTTerm},
+		{"TextKVMap key not Bin", func(w io.Writer) error {
+			InitTextKVMap(w)
+			return SendNumber(w, 1)
+		}, UTNumber},
+		{"IdKVMap key not UKey", func(w io.Writer) error {
+			InitIdKVMap(w)
+			return SendTextKey(w, "foo")
+		}, UTBin},
+		{"Term between key and value", func(w io.Writer) error {
+			InitIdKVMap(w)
+			SendUKey(w, 1)
+			return SendTerm(w)
+		}, UTTerm},
+		{"Request inside of List", func(w io.Writer) error {
+			InitList(w)
+			return InitRequest(w, 1)
+		}, UTRequest},
+		{"Answer without value", func(w io.Writer) error {
+			InitAnswer(w, 1)
+			return InitAnswer(w, 2)
+		}, UTAnswer},
+	}
+
+	for _, test := range tests {
+		w := new(bytes.Buffer)
+		vw := NewValidatingWriter(w)
+		err := test.write(vw)
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s: Expected *ValidationError, got: %v", test.name, err)
+			continue
+		}
+		if verr.Unit != test.unit {
+			t.Errorf("%s: Error names %s, expected %s", test.name, verr.Unit, test.unit)
+		}
+		if _, err := vw.Write([]byte{UTNil}); err != verr {
+			t.Errorf("%s: Error is not sticky, got: %v", test.name, err)
+		}
+	}
+
+	vw := NewValidatingWriter(new(bytes.Buffer))
+	chkerr(t, InitIdKVMap(vw), "InitIdKVMap")
+	if err := vw.CheckComplete(); err == nil {
+		t.Error("CheckComplete accepted an unterminated IdKVMap")
+	}
+}
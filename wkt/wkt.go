@@ -0,0 +1,400 @@
+// Package wkt defines canonical encodings of well-known types on top of the binproto units,
+// so services can exchange these values without inventing their own encodings.
+//
+// Every type has a Send function, a Read function (reads the unit(s) from a UnitReader) and an Action for ScanIdKVMap.
+//
+//	Type                 | Unit      | Encoding
+//	---------------------+-----------+--------------------------------------------------
+//	UUID                 | Bin       | 16 bytes
+//	netip.Addr, net.IP   | Bin       | 4 (IPv4) or 16 (IPv6) bytes, zones are not supported
+//	netip.Prefix         | Bin       | address (see above) + 1 byte prefix length
+//	*url.URL             | String    | the URL as formatted by url.URL.String
+//	Version              | IdKVMap   | 1 - Uint64 - major
+//	                     |           | 2 - Uint64 - minor
+//	                     |           | 3 - Uint64 - patch
+//	                     |           | 4 - String - pre-release (optional)
+//	                     |           | 5 - String - build metadata (optional)
+//	Decimal              | IdKVMap   | 1 - BigInt - coefficient
+//	                     |           | 2 - Number - scale (at most ±MaxScale)
+package wkt
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/silvasur/binproto"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// Errors
+var (
+	InvalidEncoding = errors.New("Invalid encoding of a well-known type")
+)
+
+// isFatal tells, if an error of a nested ScanIdKVMap left the stream in an unknown state.
+func isFatal(err error) bool {
+	switch err {
+	case nil, InvalidEncoding, binproto.KeyMissing, binproto.UnknownKey, binproto.UnexpectedTypeForKey:
+		return false
+	}
+	return true
+}
+
+// binAction builds an action for a Bin-encoded type.
+func binAction(decode func([]byte) error) binproto.GetterAction {
+	return func(data interface{}, ur binproto.UnitReader) (error, bool) {
+		return decode(data.([]byte)), false
+	}
+}
+
+// readBin reads a Bin unit and decodes it.
+func readBin(ur binproto.UnitReader, decode func([]byte) error) error {
+	data, err := binproto.ReadExpect(ur, binproto.UTBin)
+	if err != nil {
+		return err
+	}
+	return decode(data.([]byte))
+}
+
+// UUID is a universally unique identifier (RFC 4122).
+type UUID [16]byte
+
+// ParseUUID parses the canonical form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, InvalidEncoding
+	}
+	if _, err := hex.Decode(u[:], []byte(strings.Replace(s, "-", "", -1))); err != nil {
+		return u, InvalidEncoding
+	}
+	return u, nil
+}
+
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (u *UUID) decode(b []byte) error {
+	if len(b) != len(u) {
+		return InvalidEncoding
+	}
+	copy(u[:], b)
+	return nil
+}
+
+// SendUUID sends a UUID as a 16 byte Bin.
+func SendUUID(w io.Writer, u UUID) error {
+	return binproto.SendBin(w, u[:])
+}
+
+// ReadUUID reads a UUID sent by SendUUID.
+func ReadUUID(ur binproto.UnitReader) (u UUID, err error) {
+	err = readBin(ur, u.decode)
+	return
+}
+
+// ActionStoreUUID builds an action for storing a UUID. The key must have the type UTBin.
+func ActionStoreUUID(u *UUID) binproto.GetterAction {
+	return binAction(u.decode)
+}
+
+func addrBytes(a netip.Addr) ([]byte, error) {
+	if !a.IsValid() || a.Zone() != "" {
+		return nil, InvalidEncoding
+	}
+	return a.AsSlice(), nil
+}
+
+func decodeAddr(b []byte) (netip.Addr, error) {
+	if len(b) != 4 && len(b) != 16 {
+		return netip.Addr{}, InvalidEncoding
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a, nil
+}
+
+// SendAddr sends an IP address. Invalid addresses and addresses with a zone are rejected with InvalidEncoding.
+func SendAddr(w io.Writer, a netip.Addr) error {
+	b, err := addrBytes(a)
+	if err != nil {
+		return err
+	}
+	return binproto.SendBin(w, b)
+}
+
+// ReadAddr reads an IP address sent by SendAddr or SendIP.
+func ReadAddr(ur binproto.UnitReader) (a netip.Addr, err error) {
+	err = readBin(ur, func(b []byte) (err error) {
+		a, err = decodeAddr(b)
+		return
+	})
+	return
+}
+
+// ActionStoreAddr builds an action for storing an IP address. The key must have the type UTBin.
+func ActionStoreAddr(a *netip.Addr) binproto.GetterAction {
+	return binAction(func(b []byte) (err error) {
+		*a, err = decodeAddr(b)
+		return
+	})
+}
+
+// SendIP sends a net.IP. IPv4 addresses are always sent as 4 bytes, even if ip is in the 16 byte form.
+func SendIP(w io.Writer, ip net.IP) error {
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return InvalidEncoding
+	}
+	return SendAddr(w, a.Unmap())
+}
+
+// ReadIP reads an IP address sent by SendIP or SendAddr.
+func ReadIP(ur binproto.UnitReader) (net.IP, error) {
+	a, err := ReadAddr(ur)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(a.AsSlice()), nil
+}
+
+// ActionStoreIP builds an action for storing an IP address as net.IP. The key must have the type UTBin.
+func ActionStoreIP(ip *net.IP) binproto.GetterAction {
+	return binAction(func(b []byte) error {
+		a, err := decodeAddr(b)
+		if err != nil {
+			return err
+		}
+		*ip = net.IP(a.AsSlice())
+		return nil
+	})
+}
+
+func decodePrefix(b []byte) (netip.Prefix, error) {
+	if len(b) == 0 {
+		return netip.Prefix{}, InvalidEncoding
+	}
+	a, err := decodeAddr(b[:len(b)-1])
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	bits := int(b[len(b)-1])
+	if bits > a.BitLen() {
+		return netip.Prefix{}, InvalidEncoding
+	}
+	return netip.PrefixFrom(a, bits), nil
+}
+
+// SendPrefix sends an IP prefix, see SendAddr for the restrictions of the address.
+func SendPrefix(w io.Writer, p netip.Prefix) error {
+	if !p.IsValid() {
+		return InvalidEncoding
+	}
+	b, err := addrBytes(p.Addr())
+	if err != nil {
+		return err
+	}
+	return binproto.SendBin(w, append(b, byte(p.Bits())))
+}
+
+// ReadPrefix reads an IP prefix sent by SendPrefix.
+func ReadPrefix(ur binproto.UnitReader) (p netip.Prefix, err error) {
+	err = readBin(ur, func(b []byte) (err error) {
+		p, err = decodePrefix(b)
+		return
+	})
+	return
+}
+
+// ActionStorePrefix builds an action for storing an IP prefix. The key must have the type UTBin.
+func ActionStorePrefix(p *netip.Prefix) binproto.GetterAction {
+	return binAction(func(b []byte) (err error) {
+		*p, err = decodePrefix(b)
+		return
+	})
+}
+
+// SendURL sends a URL as a String. A nil URL is rejected with InvalidEncoding.
+func SendURL(w io.Writer, u *url.URL) error {
+	if u == nil {
+		return InvalidEncoding
+	}
+	return binproto.SendString(w, u.String())
+}
+
+// ReadURL reads and parses a URL sent by SendURL.
+func ReadURL(ur binproto.UnitReader) (*url.URL, error) {
+	data, err := binproto.ReadExpect(ur, binproto.UTString)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(data.(string))
+}
+
+// ActionStoreURL builds an action for storing a URL. The key must have the type UTString.
+func ActionStoreURL(u **url.URL) binproto.GetterAction {
+	return func(data interface{}, ur binproto.UnitReader) (error, bool) {
+		parsed, err := url.Parse(data.(string))
+		if err != nil {
+			return err, false
+		}
+		*u = parsed
+		return nil, false
+	}
+}
+
+// mapAction builds an action for an IdKVMap-encoded type.
+func mapAction(scan func(binproto.UnitReader) error) binproto.GetterAction {
+	return func(data interface{}, ur binproto.UnitReader) (error, bool) {
+		err := scan(ur)
+		return err, isFatal(err)
+	}
+}
+
+// readMap reads the UTIdKVMap unit and scans the map.
+func readMap(ur binproto.UnitReader, scan func(binproto.UnitReader) error) error {
+	if _, err := binproto.ReadExpect(ur, binproto.UTIdKVMap); err != nil {
+		return err
+	}
+	return scan(ur)
+}
+
+// Version is a semantic version (https://semver.org).
+type Version struct {
+	Major, Minor, Patch uint64
+	Pre                 string // Pre-release, e.g. "rc.1"
+	Build               string // Build metadata
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// SendVersion sends a Version as an IdKVMap. Empty Pre and Build fields are omitted.
+func SendVersion(w io.Writer, v Version) error {
+	return binproto.NewBuilder(w).IdKVMap(func(m *binproto.IdKVMapBuilder) {
+		m.Uint64(1, v.Major).Uint64(2, v.Minor).Uint64(3, v.Patch)
+		if v.Pre != "" {
+			m.String(4, v.Pre)
+		}
+		if v.Build != "" {
+			m.String(5, v.Build)
+		}
+	}).Err()
+}
+
+func (v *Version) scan(ur binproto.UnitReader) error {
+	var nv Version
+	err := binproto.ScanIdKVMap(ur, map[byte]binproto.UKeyGetter{
+		1: {Type: binproto.UTUint64, Action: binproto.ActionStoreUint64(&nv.Major)},
+		2: {Type: binproto.UTUint64, Action: binproto.ActionStoreUint64(&nv.Minor)},
+		3: {Type: binproto.UTUint64, Action: binproto.ActionStoreUint64(&nv.Patch)},
+		4: {Type: binproto.UTString, Optional: true, Action: binproto.ActionStoreString(&nv.Pre)},
+		5: {Type: binproto.UTString, Optional: true, Action: binproto.ActionStoreString(&nv.Build)},
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	*v = nv
+	return nil
+}
+
+// ReadVersion reads a Version sent by SendVersion.
+func ReadVersion(ur binproto.UnitReader) (v Version, err error) {
+	err = readMap(ur, v.scan)
+	return
+}
+
+// ActionStoreVersion builds an action for storing a Version. The key must have the type UTIdKVMap.
+func ActionStoreVersion(v *Version) binproto.GetterAction {
+	return mapAction(v.scan)
+}
+
+// MaxScale is the maximal absolute value of Decimal.Scale that can be sent and read.
+const MaxScale = 1000
+
+// Decimal is an exact decimal number: Coef * 10^(-Scale).
+type Decimal struct {
+	Coef  *big.Int
+	Scale int32
+}
+
+// String formats the number in plain decimal notation. If the absolute value of Scale is larger than MaxScale,
+// the exponential notation (e.g. 123e-5000) is used instead.
+func (d Decimal) String() string {
+	coef := d.Coef
+	if coef == nil {
+		coef = new(big.Int)
+	}
+	scale := int(d.Scale)
+	switch {
+	case scale < -MaxScale || scale > MaxScale:
+		return fmt.Sprintf("%se%d", coef, -int64(d.Scale))
+	case scale <= 0:
+		return coef.String() + strings.Repeat("0", -scale)
+	}
+
+	digits := new(big.Int).Abs(coef).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	point := len(digits) - scale
+	s := digits[:point] + "." + digits[point:]
+	if coef.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// SendDecimal sends a Decimal as an IdKVMap. A nil Coef or a Scale outside of ±MaxScale is rejected with InvalidEncoding.
+func SendDecimal(w io.Writer, d Decimal) error {
+	if d.Coef == nil || d.Scale < -MaxScale || d.Scale > MaxScale {
+		return InvalidEncoding
+	}
+	return binproto.NewBuilder(w).IdKVMap(func(m *binproto.IdKVMapBuilder) {
+		m.BigInt(1, d.Coef).Number(2, int64(d.Scale))
+	}).Err()
+}
+
+func (d *Decimal) scan(ur binproto.UnitReader) error {
+	var coef *big.Int
+	var scale int64
+	err := binproto.ScanIdKVMap(ur, map[byte]binproto.UKeyGetter{
+		1: {Type: binproto.UTBigInt, Action: binproto.ActionStoreBigInt(&coef)},
+		2: {Type: binproto.UTNumber, Action: binproto.ActionStoreNumber(&scale)},
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	if scale < -MaxScale || scale > MaxScale {
+		return InvalidEncoding
+	}
+	*d = Decimal{coef, int32(scale)}
+	return nil
+}
+
+// ReadDecimal reads a Decimal sent by SendDecimal. A Scale outside of ±MaxScale is rejected with InvalidEncoding.
+func ReadDecimal(ur binproto.UnitReader) (d Decimal, err error) {
+	err = readMap(ur, d.scan)
+	return
+}
+
+// ActionStoreDecimal builds an action for storing a Decimal. The key must have the type UTIdKVMap.
+func ActionStoreDecimal(d *Decimal) binproto.GetterAction {
+	return mapAction(d.scan)
+}
@@ -0,0 +1,183 @@
+package wkt
+
+import (
+	"bytes"
+	"github.com/silvasur/binproto"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	uuid, err := ParseUUID("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	if err != nil {
+		t.Fatalf("ParseUUID failed: %s", err)
+	}
+	addr := netip.MustParseAddr("2001:db8::1")
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	u, _ := url.Parse("https://example.com/path?q=1#frag")
+	version := Version{Major: 1, Minor: 2, Patch: math.MaxUint64, Pre: "rc.1"}
+	dec := Decimal{big.NewInt(-12345), 2}
+
+	w := new(bytes.Buffer)
+	for _, err := range []error{
+		SendUUID(w, uuid),
+		SendAddr(w, addr),
+		SendIP(w, net.ParseIP("192.168.1.1")), // 16 byte form
+		SendPrefix(w, prefix),
+		SendURL(w, u),
+		SendVersion(w, version),
+		SendDecimal(w, dec),
+	} {
+		if err != nil {
+			t.Fatalf("Sending failed: %s", err)
+		}
+	}
+
+	ur := binproto.NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if got, err := ReadUUID(ur); err != nil || got != uuid || got.String() != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+		t.Errorf("Wrong UUID: %s (err: %v)", got, err)
+	}
+	if got, err := ReadAddr(ur); err != nil || got != addr {
+		t.Errorf("Wrong address: %s (err: %v)", got, err)
+	}
+	if got, err := ReadIP(ur); err != nil || len(got) != 4 || !got.Equal(net.IPv4(192, 168, 1, 1)) {
+		t.Errorf("Wrong IP: %v (err: %v)", got, err)
+	}
+	if got, err := ReadPrefix(ur); err != nil || got != prefix {
+		t.Errorf("Wrong prefix: %s (err: %v)", got, err)
+	}
+	if got, err := ReadURL(ur); err != nil || got.String() != u.String() {
+		t.Errorf("Wrong URL: %v (err: %v)", got, err)
+	}
+	if got, err := ReadVersion(ur); err != nil || got != version || got.String() != "1.2.18446744073709551615-rc.1" {
+		t.Errorf("Wrong version: %s (err: %v)", got, err)
+	}
+	if got, err := ReadDecimal(ur); err != nil || got.Coef.Cmp(dec.Coef) != 0 || got.Scale != 2 || got.String() != "-123.45" {
+		t.Errorf("Wrong decimal: %s (err: %v)", got, err)
+	}
+}
+
+func TestActions(t *testing.T) {
+	w := new(bytes.Buffer)
+	err := binproto.NewBuilder(w).IdKVMap(func(m *binproto.IdKVMapBuilder) {
+		m.Bin(1, make([]byte, 16))
+		m.Bin(2, []byte{127, 0, 0, 1, 24})
+		m.IdKVMap(3, func(m *binproto.IdKVMapBuilder) {
+			m.Uint64(1, 2).Uint64(2, 0).Uint64(3, 1).String(5, "build.7")
+		})
+		m.IdKVMap(4, func(m *binproto.IdKVMapBuilder) {
+			m.BigInt(1, big.NewInt(5)).Number(2, 3)
+		})
+	}).Number(42).Err()
+	if err != nil {
+		t.Fatalf("Building failed: %s", err)
+	}
+
+	var uuid UUID
+	var prefix netip.Prefix
+	var version Version
+	var dec Decimal
+
+	ur := binproto.NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if _, err := binproto.ReadExpect(ur, binproto.UTIdKVMap); err != nil {
+		t.Fatal(err)
+	}
+	err = binproto.ScanIdKVMap(ur, map[byte]binproto.UKeyGetter{
+		1: {Type: binproto.UTBin, Action: ActionStoreUUID(&uuid)},
+		2: {Type: binproto.UTBin, Action: ActionStorePrefix(&prefix)},
+		3: {Type: binproto.UTIdKVMap, Action: ActionStoreVersion(&version)},
+		4: {Type: binproto.UTIdKVMap, Action: ActionStoreDecimal(&dec)},
+	}, true)
+	if err != nil {
+		t.Fatalf("ScanIdKVMap failed: %s", err)
+	}
+
+	if uuid != (UUID{}) {
+		t.Errorf("Wrong UUID: %s", uuid)
+	}
+	if prefix != netip.MustParsePrefix("127.0.0.1/24") {
+		t.Errorf("Wrong prefix: %s", prefix)
+	}
+	if version.String() != "2.0.1+build.7" {
+		t.Errorf("Wrong version: %s", version)
+	}
+	if dec.String() != "0.005" {
+		t.Errorf("Wrong decimal: %s", dec)
+	}
+
+	if n, err := binproto.ReadExpect(ur, binproto.UTNumber); err != nil || n.(int64) != 42 {
+		t.Errorf("Wrong number after map: %v (err: %v)", n, err)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	w := new(bytes.Buffer)
+	if err := SendAddr(w, netip.MustParseAddr("fe80::1%eth0")); err != InvalidEncoding {
+		t.Errorf("Zoned address: expected InvalidEncoding, got %v", err)
+	}
+	if err := SendURL(w, nil); err != InvalidEncoding {
+		t.Errorf("nil URL: expected InvalidEncoding, got %v", err)
+	}
+	if _, err := ParseUUID("not-a-uuid"); err != InvalidEncoding {
+		t.Errorf("ParseUUID: expected InvalidEncoding, got %v", err)
+	}
+
+	// An invalid value must not break the scan of the surrounding map.
+	err := binproto.NewBuilder(w).IdKVMap(func(m *binproto.IdKVMapBuilder) {
+		m.Bin(1, []byte{1, 2, 3})
+		m.IdKVMap(2, func(m *binproto.IdKVMapBuilder) {
+			m.BigInt(1, big.NewInt(1)).Number(2, 1<<40)
+		})
+	}).Number(42).Err()
+	if err != nil {
+		t.Fatalf("Building failed: %s", err)
+	}
+
+	var uuid UUID
+	var dec Decimal
+	ur := binproto.NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if _, err := binproto.ReadExpect(ur, binproto.UTIdKVMap); err != nil {
+		t.Fatal(err)
+	}
+	err = binproto.ScanIdKVMap(ur, map[byte]binproto.UKeyGetter{
+		1: {Type: binproto.UTBin, Action: ActionStoreUUID(&uuid)},
+		2: {Type: binproto.UTIdKVMap, Action: ActionStoreDecimal(&dec)},
+	}, true)
+	if err != InvalidEncoding {
+		t.Errorf("Expected InvalidEncoding, got %v", err)
+	}
+	if n, err := binproto.ReadExpect(ur, binproto.UTNumber); err != nil || n.(int64) != 42 {
+		t.Errorf("Wrong number after map: %v (err: %v)", n, err)
+	}
+
+	w.Reset()
+	binproto.NewBuilder(w).IdKVMap(func(m *binproto.IdKVMapBuilder) {
+		m.BigInt(1, big.NewInt(1)).Number(2, -1<<40)
+	})
+	ur = binproto.NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if _, err := ReadDecimal(ur); err != InvalidEncoding {
+		t.Errorf("Scale out of range: expected InvalidEncoding, got %v", err)
+	}
+
+	w.Reset()
+	binproto.NewBuilder(w).IdKVMap(func(m *binproto.IdKVMapBuilder) {
+		m.BigInt(1, big.NewInt(1)).Number(2, math.MinInt32)
+	})
+	ur = binproto.NewSimpleUnitReader(bytes.NewReader(w.Bytes()))
+	if _, err := ReadDecimal(ur); err != InvalidEncoding {
+		t.Errorf("Scale MinInt32: expected InvalidEncoding, got %v", err)
+	}
+	if err := SendDecimal(w, Decimal{big.NewInt(1), MaxScale + 1}); err != InvalidEncoding {
+		t.Errorf("Sending a large scale: expected InvalidEncoding, got %v", err)
+	}
+	if s := (Decimal{big.NewInt(-12), math.MinInt32}).String(); s != "-12e2147483648" {
+		t.Errorf("Wrong format of a huge exponent: %s", s)
+	}
+	if s := (Decimal{big.NewInt(5), -3}).String(); s != "5000" {
+		t.Errorf("Wrong format of a negative scale: %s", s)
+	}
+}